@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/component-base/logs"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
@@ -20,11 +25,38 @@ const (
 
 func main() {
 	var ctx controller.Context
-	var metricsBindAddress, healthProbeBindAddress string
+	var opts controller.Options
 
-	pflag.StringVar(&metricsBindAddress, "metrics-bind-address", ":8080", "Address to bind to access to the metrics")
-	pflag.StringVar(&healthProbeBindAddress, "health-probe-bind-address", ":8081", "Address to bind to access to health probes")
-	pflag.StringSliceVar(&ctx.IgnoredNamespaces, "ignore-namespaces", []string{"kube-system"}, "List of namespaces to be ignored by the controller")
+	pflag.StringVar(&opts.MetricsBindAddress, "metrics-bind-address", ":8080", "Address to bind to access to the metrics")
+	pflag.StringVar(&opts.HealthProbeBindAddress, "health-probe-bind-address", ":8081", "Address to bind to access to health probes")
+	pflag.BoolVar(&opts.LeaderElection, "leader-elect", false, "Enable leader election, in order to run several replicas of the controller")
+	pflag.StringVar(&opts.LeaderElectionNamespace, "leader-election-namespace", "", "Namespace where the leader election configmap will be created (default to the controller namespace)")
+	pflag.DurationVar(&opts.ResyncPeriod, "resync-period", 0, "Period of the full resync of all secrets, which detects and fixes drifted owned secrets (disabled if zero)")
+	pflag.DurationVar(&opts.StuckWorkqueueTimeout, "stuck-workqueue-timeout", 5*time.Minute, "Maximum duration without progress while items are pending, before the controller is considered as not alive")
+	pflag.IntVar(&opts.Webhook.Port, "webhook-port", 0, "Port where the admission webhook server listens (disabled if 0)")
+	pflag.StringVar(&opts.Webhook.CertDir, "webhook-cert-dir", "/tmp/sync-secrets-controller/certs", "Directory where the self-managed webhook certificates are written")
+	pflag.StringVar(&opts.Webhook.ServiceName, "webhook-service-name", "sync-secrets-controller", "Name of the service used to reach the admission webhook server")
+	pflag.StringVar(&opts.Webhook.ServiceNamespace, "webhook-service-namespace", "default", "Namespace of the service used to reach the admission webhook server")
+	pflag.StringSliceVar(&opts.Webhook.ValidatingWebhookConfigurations, "webhook-validating-configurations", []string{"sync-secrets-controller", "sync-secrets-controller-owned-secrets"}, "Names of the ValidatingWebhookConfigurations where the CA bundle is injected")
+	pflag.StringSliceVar(&opts.Webhook.MutatingWebhookConfigurations, "webhook-mutating-configurations", []string{"sync-secrets-controller-annotator"}, "Names of the MutatingWebhookConfigurations where the CA bundle is injected")
+	pflag.BoolVar(&opts.ProtectOwnedSecrets, "webhook-protect-owned-secrets", false, "Deny all updates and deletions of owned secrets not done by the controller (requires the webhook server)")
+	pflag.StringVar(&opts.ServiceAccount, "service-account", "default/sync-secrets-controller", "Service account used by the controller, as <namespace>/<name>")
+	pflag.StringVar(&opts.AuditLog, "audit-log", "", "File where the audit log of all operations done on owned secrets is written ('-' for stdout)")
+	pflag.StringVar(&opts.AuditWebhookURL, "audit-webhook-url", "", "URL where the audit records are sent, with a POST request per record")
+	pflag.StringVar(&opts.AuditKeyFile, "audit-key-file", "", "File containing the HMAC key signing the audit records (required by the audit log)")
+	pflag.BoolVar(&ctx.AuthorizeAnnotator, "authorize-annotator", false, "Only synchronize secrets into namespaces where the user who has annotated them can create secrets (requires the webhook server)")
+	pflag.StringSliceVar(&ctx.DeniedSecretTypes, "deny-secret-types", []string{string(corev1.SecretTypeServiceAccountToken), string(corev1.SecretTypeBootstrapToken), "helm.sh/release.v1"}, "List of secret types which must never be synchronized")
+	pflag.BoolVar(&ctx.DryRun, "dry-run", false, "Only report the operations which would be done on owned secrets, without writing anything")
+	pflag.BoolVar(&ctx.Paused, "paused", false, "Pause the synchronization of all secrets; owned secrets are no longer written until the controller is restarted without this flag")
+	pflag.StringVar(&ctx.ConflictPolicy, "conflict-policy", controller.SkipConflictPolicy, "Default policy applied on pre-existing secrets not owned by the controller (skip, adopt, adopt-if-identical or fail)")
+	pflag.StringSliceVar(&ctx.WatchNamespaces, "watch-namespaces", nil, "List of namespaces watched by the controller, which only requires namespaced permissions (all namespaces if empty)")
+	pflag.StringVar(&ctx.RemoteClustersNamespace, "remote-clusters-namespace", "", "Namespace of the kubeconfig secrets defining the remote clusters where secrets are also synchronized (disabled if empty)")
+	printRBAC := pflag.Bool("print-rbac", false, "Print the Roles and RoleBindings required by the namespace-scoped mode (--watch-namespaces) and exit")
+	printRolloutRBAC := pflag.Bool("print-rbac-rollout", false, "Also print the Roles required to restart the workloads of the secrets with 'rollout-on-change' (with --print-rbac)")
+	pflag.StringSliceVar(&ctx.IgnoredNamespaces, "ignore-namespaces", []string{"kube-system"}, "List of namespaces to be ignored by the controller (glob patterns like 'kube-*' are supported)")
+	ignoredNamespaceSelector := pflag.String("ignore-namespace-selector", "", "Label selector of the namespaces to be ignored by the controller")
+	pflag.StringSliceVar(&ctx.SourceNamespaces, "source-namespaces", nil, "List of namespaces allowed to publish synchronized secrets (glob patterns are supported; all namespaces if empty)")
+	sourceNamespaceSelector := pflag.String("source-namespace-selector", "", "Label selector of the namespaces allowed to publish synchronized secrets")
 
 	pflag.StringSliceVar(&ctx.ProtectedLabels, "protected-labels", nil, "List of protected labels which must not be copied")
 	pflag.StringSliceVar(&ctx.ProtectedAnnotations, "protected-annotations", nil, "List of protected annotations which must not be copied")
@@ -32,10 +64,44 @@ func main() {
 	logs.InitLogs()
 	kflag.InitFlags()
 
+	if *printRBAC {
+		manifests, err := controller.NamespacedRBACManifests(ctx.WatchNamespaces, opts.ServiceAccount, *printRolloutRBAC, opts.LeaderElection, opts.LeaderElectionNamespace)
+		if err != nil {
+			klog.Fatalf("Unable to generate RBAC manifests: %s", err)
+		}
+		fmt.Print(string(manifests))
+		return
+	}
+
 	klog.V(1).Infof("%s version: %s", controllerName, version.Info())
 	klog.V(4).Infof(version.Print(controllerName))
 	metrics.Registry.MustRegister(version.NewCollector(controllerNameMetric))
 
-	ctrl := controller.NewController(metricsBindAddress, healthProbeBindAddress, ctx)
+	if err := controller.ValidateNamespacePatterns(ctx.IgnoredNamespaces); err != nil {
+		klog.Fatalf("Invalid --ignore-namespaces: %s", err)
+	}
+	if *ignoredNamespaceSelector != "" {
+		selector, err := labels.Parse(*ignoredNamespaceSelector)
+		if err != nil {
+			klog.Fatalf("Invalid --ignore-namespace-selector: %s", err)
+		}
+		ctx.IgnoredNamespaceSelector = selector
+	}
+	if err := controller.ValidateNamespacePatterns(ctx.SourceNamespaces); err != nil {
+		klog.Fatalf("Invalid --source-namespaces: %s", err)
+	}
+	if *sourceNamespaceSelector != "" {
+		selector, err := labels.Parse(*sourceNamespaceSelector)
+		if err != nil {
+			klog.Fatalf("Invalid --source-namespace-selector: %s", err)
+		}
+		ctx.SourceNamespaceSelector = selector
+	}
+
+	if err := controller.ValidateConflictPolicy(ctx.ConflictPolicy); err != nil {
+		klog.Fatalf("Invalid --conflict-policy: %s", err)
+	}
+
+	ctrl := controller.NewController(opts, ctx)
 	ctrl.Run(signals.SetupSignalHandler())
 }
@@ -5,7 +5,9 @@ go 1.14
 require (
 	github.com/cucumber/godog v0.10.0
 	github.com/cucumber/messages-go/v10 v10.0.3
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/google/uuid v1.1.1
+	github.com/prometheus/client_golang v1.0.0
 	github.com/prometheus/common v0.4.1
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1
@@ -19,4 +21,5 @@ require (
 	k8s.io/component-base v0.18.2
 	k8s.io/klog v1.0.0
 	sigs.k8s.io/controller-runtime v0.6.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,257 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// bufferSize is the maximum number of records waiting to be written to
+	// a sink.
+	bufferSize = 1024
+	// queueTimeout is the maximum time to wait for a full sink before
+	// dropping a record.
+	queueTimeout = 5 * time.Second
+)
+
+type (
+	// Record is a single entry of the audit log. It never contains secret
+	// values, only key names and hashes.
+	Record struct {
+		Time time.Time `json:"time"`
+		// Operation is the operation done on the owned secret (create,
+		// update or delete).
+		Operation string `json:"operation"`
+		// Reason is the reason of the operation (the triggering event).
+		Reason string `json:"reason"`
+		// Source is the managed secret and Target is the owned secret.
+		Source Object `json:"source"`
+		Target Object `json:"target"`
+		// Cluster is the remote cluster of the owned secret (empty for the
+		// local cluster).
+		Cluster string `json:"cluster,omitempty"`
+		// Keys are the data key names of the owned secret and SecretHash
+		// is the hash of its content.
+		Keys       []string `json:"keys,omitempty"`
+		SecretHash string   `json:"secretHash,omitempty"`
+
+		// PreviousHash is the hash of the previous record written to the
+		// same sink and Hash is the HMAC of this record, including
+		// PreviousHash; any modification of a record breaks the chain.
+		PreviousHash string `json:"previousHash"`
+		Hash         string `json:"hash"`
+	}
+
+	// Object identifies a secret.
+	Object struct {
+		Namespace string    `json:"namespace"`
+		Name      string    `json:"name"`
+		UID       types.UID `json:"uid,omitempty"`
+	}
+
+	// Sink writes the serialized audit records.
+	Sink interface {
+		Write(record []byte) error
+	}
+
+	// ChainedSink is a sink continuing an existing audit log.
+	ChainedSink interface {
+		Sink
+		// LastHash returns the hash of the last record of the audit log.
+		LastHash() string
+	}
+
+	// Logger chains all audit records and writes them asynchronously to
+	// its sinks. Each sink has its own chain, which only advances when a
+	// record has been written successfully.
+	Logger struct {
+		key     []byte
+		chains  []*chain
+		onError func(error)
+		timeout time.Duration
+		pending sync.WaitGroup
+		done    sync.WaitGroup
+		closed  bool
+		mx      sync.RWMutex
+	}
+
+	// chain writes the records queued for a sink.
+	chain struct {
+		sink     Sink
+		records  chan Record
+		lastHash string
+	}
+)
+
+// New creates a new audit logger signing all records with the given HMAC
+// key. Write failures are reported to onError. Chains of ChainedSink
+// continue their audit log; the chains of other sinks start with an empty
+// hash.
+func New(key []byte, onError func(error), sinks ...Sink) *Logger {
+	l := &Logger{key: key, onError: onError, timeout: queueTimeout}
+	for _, sink := range sinks {
+		c := &chain{sink: sink, records: make(chan Record, bufferSize)}
+		if sink, isChained := sink.(ChainedSink); isChained {
+			c.lastHash = sink.LastHash()
+		}
+		l.chains = append(l.chains, c)
+
+		l.done.Add(1)
+		go l.run(c)
+	}
+	return l
+}
+
+// Log queues the given record on all sinks. It only waits for the sinks
+// when they are full, up to a timeout; the record is then dropped from the
+// full sinks and an error is returned. A nil logger drops all records.
+func (l *Logger) Log(record Record) error {
+	if l == nil {
+		return nil
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	// NOTE: records are only queued under the lock; sinks are written by
+	//       the chain goroutines, without any lock
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	if l.closed {
+		return nil
+	}
+
+	// NOTE: the timeout is shared by all sinks, so a record never waits
+	//       more than the timeout, whatever the number of full sinks
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	var dropped int
+	for _, c := range l.chains {
+		l.pending.Add(1)
+		if !c.queue(ctx, record) {
+			l.pending.Done()
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		return fmt.Errorf("failed to queue audit record: %d sink(s) are still full after %s", dropped, l.timeout)
+	}
+	return nil
+}
+
+// Flush waits until all queued records have been written.
+func (l *Logger) Flush() {
+	if l == nil {
+		return
+	}
+	l.pending.Wait()
+}
+
+// Close writes all queued records and stops the logger. Records logged
+// after Close are dropped.
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+
+	l.mx.Lock()
+	if l.closed {
+		l.mx.Unlock()
+		return
+	}
+	l.closed = true
+	for _, c := range l.chains {
+		close(c.records)
+	}
+	l.mx.Unlock()
+	l.done.Wait()
+}
+
+// queue queues the given record, waiting until the chain has room for it or
+// the given context is done. It returns false if the record was not queued.
+func (c *chain) queue(ctx context.Context, record Record) bool {
+	select {
+	case c.records <- record:
+		return true
+	default:
+	}
+
+	select {
+	case c.records <- record:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run chains and writes the records queued for the given sink.
+func (l *Logger) run(c *chain) {
+	defer l.done.Done()
+
+	for record := range c.records {
+		record.PreviousHash = c.lastHash
+		record.Hash = ""
+		record.Hash = hashRecord(l.key, record)
+
+		raw, err := json.Marshal(record)
+		if err == nil {
+			err = c.sink.Write(raw)
+		}
+
+		switch {
+		case err == nil:
+			c.lastHash = record.Hash
+		case l.onError != nil:
+			l.onError(fmt.Errorf("failed to write audit record: %w", err))
+		}
+		l.pending.Done()
+	}
+}
+
+// Verify reads an audit log and checks that its records are correctly
+// chained and signed with the given HMAC key. It returns the hash of the
+// last record.
+func Verify(reader io.Reader, key []byte) (string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+
+	lastHash := ""
+	for line := 1; scanner.Scan(); line++ {
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("invalid audit record at line %d: %w", line, err)
+		}
+
+		hash := record.Hash
+		record.Hash = ""
+		switch {
+		case line > 1 && record.PreviousHash != lastHash:
+			return "", fmt.Errorf("audit record at line %d is not chained to the previous one", line)
+		case !hmac.Equal([]byte(hashRecord(key, record)), []byte(hash)):
+			return "", fmt.Errorf("audit record at line %d has been modified", line)
+		}
+		lastHash = hash
+	}
+	return lastHash, scanner.Err()
+}
+
+// hashRecord computes the HMAC of the given record.
+func hashRecord(key []byte, record Record) string {
+	raw, _ := json.Marshal(record)
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(raw)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,229 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var key = []byte("audit-key")
+
+var record = Record{
+	Operation:  "create",
+	Reason:     "Synced",
+	Source:     Object{Namespace: "default", Name: "secret", UID: "00000000-0000-0000-0000-000000000000"},
+	Target:     Object{Namespace: "kube-public", Name: "secret"},
+	Keys:       []string{"password", "username"},
+	SecretHash: "1a2b3c4d",
+}
+
+func TestLogger_Log(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := New(key, nil, NewStreamSink(buffer))
+
+	require.NoError(t, logger.Log(record))
+	require.NoError(t, logger.Log(record))
+	logger.Close()
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	first, second := Record{}, Record{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Empty(t, first.PreviousHash)
+	assert.Equal(t, first.Hash, second.PreviousHash)
+	assert.Equal(t, record.Keys, second.Keys)
+
+	lastHash, err := Verify(strings.NewReader(buffer.String()), key)
+	require.NoError(t, err)
+	assert.Equal(t, logger.chains[0].lastHash, lastHash)
+
+	t.Run("WithNilLogger", func(t *testing.T) {
+		var logger *Logger
+		assert.NoError(t, logger.Log(record))
+	})
+	t.Run("WithClosedLogger", func(t *testing.T) {
+		assert.NoError(t, logger.Log(record))
+		assert.Equal(t, 2, strings.Count(buffer.String(), "\n"))
+	})
+}
+
+// failingSink fails to write the first record.
+type failingSink struct {
+	bytes.Buffer
+	failed bool
+}
+
+func (s *failingSink) Write(record []byte) error {
+	if !s.failed {
+		s.failed = true
+		return fmt.Errorf("connection refused")
+	}
+	_, err := s.Buffer.Write(append(record, '\n'))
+	return err
+}
+
+func TestLogger_WithFailingSink(t *testing.T) {
+	stream, failing := &bytes.Buffer{}, &failingSink{}
+	var errs []error
+	logger := New(key, func(err error) { errs = append(errs, err) }, NewStreamSink(stream), failing)
+
+	require.NoError(t, logger.Log(record))
+	require.NoError(t, logger.Log(record))
+	logger.Close()
+	assert.Equal(t, []error{fmt.Errorf("failed to write audit record: %w", fmt.Errorf("connection refused"))}, errs)
+
+	// NOTE: each sink has its own chain, which only advances when a record
+	//       has been written
+	_, err := Verify(strings.NewReader(stream.String()), key)
+	assert.NoError(t, err)
+	_, err = Verify(strings.NewReader(failing.String()), key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(stream.String(), "\n"))
+	assert.Equal(t, 1, strings.Count(failing.String(), "\n"))
+}
+
+// blockingSink blocks all writes until it is released.
+type blockingSink struct {
+	bytes.Buffer
+	released chan struct{}
+}
+
+func (s *blockingSink) Write(record []byte) error {
+	<-s.released
+	_, err := s.Buffer.Write(append(record, '\n'))
+	return err
+}
+
+func TestLogger_WithFullSink(t *testing.T) {
+	blocking := &blockingSink{released: make(chan struct{})}
+	logger := New(key, nil, blocking)
+	logger.timeout = 10 * time.Millisecond
+
+	// NOTE: the first record is being written while the next ones fill the
+	//       buffer of the sink
+	for i := 0; i <= bufferSize; i++ {
+		require.NoError(t, logger.Log(record))
+	}
+	assert.EqualError(t, logger.Log(record), "failed to queue audit record: 1 sink(s) are still full after 10ms")
+
+	t.Run("WithReleasedSink", func(t *testing.T) {
+		logger.timeout = time.Minute
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(blocking.released)
+		}()
+		assert.NoError(t, logger.Log(record))
+	})
+
+	logger.Close()
+	_, err := Verify(strings.NewReader(blocking.String()), key)
+	assert.NoError(t, err)
+	assert.Equal(t, bufferSize+2, strings.Count(blocking.String(), "\n"))
+}
+
+func TestVerify(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := New(key, nil, NewStreamSink(buffer))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, logger.Log(record))
+	}
+	logger.Close()
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+	t.Run("WithModifiedRecord", func(t *testing.T) {
+		modified := append([]string{}, lines...)
+		modified[1] = strings.Replace(modified[1], "kube-public", "kube-system", 1)
+		_, err := Verify(strings.NewReader(strings.Join(modified, "\n")), key)
+		assert.EqualError(t, err, "audit record at line 2 has been modified")
+	})
+
+	t.Run("WithRemovedRecord", func(t *testing.T) {
+		_, err := Verify(strings.NewReader(lines[0]+"\n"+lines[2]), key)
+		assert.EqualError(t, err, "audit record at line 2 is not chained to the previous one")
+	})
+
+	t.Run("WithRehashedRecord", func(t *testing.T) {
+		// NOTE: a modified record cannot be signed again without the key
+		forged := Record{}
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &forged))
+		forged.Target.Namespace = "kube-system"
+		forged.Hash = ""
+		forged.Hash = hashRecord([]byte("another-key"), forged)
+		raw, err := json.Marshal(forged)
+		require.NoError(t, err)
+
+		_, err = Verify(strings.NewReader(lines[0]+"\n"+lines[1]+"\n"+string(raw)), key)
+		assert.EqualError(t, err, "audit record at line 3 has been modified")
+	})
+}
+
+func TestOpenFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := OpenFileSink(path, key)
+	require.NoError(t, err)
+	assert.Empty(t, sink.LastHash())
+	logger := New(key, nil, sink)
+	require.NoError(t, logger.Log(record))
+	logger.Close()
+
+	// NOTE: the chain must continue after a restart
+	sink, err = OpenFileSink(path, key)
+	require.NoError(t, err)
+	assert.Equal(t, logger.chains[0].lastHash, sink.LastHash())
+	logger = New(key, nil, sink)
+	require.NoError(t, logger.Log(record))
+	logger.Close()
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	_, err = Verify(bytes.NewReader(raw), key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(raw), "\n"))
+
+	t.Run("WithAnotherKey", func(t *testing.T) {
+		_, err := OpenFileSink(path, []byte("another-key"))
+		assert.EqualError(t, err, "failed to verify audit log "+path+": audit record at line 1 has been modified")
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	logger := New(key, nil, NewWebhookSink(server.URL))
+	require.NoError(t, logger.Log(record))
+	logger.Close()
+	assert.Contains(t, string(received), `"operation":"create"`)
+
+	t.Run("WithFailingWebhook", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		var errs []error
+		logger := New(key, func(err error) { errs = append(errs, err) }, NewWebhookSink(server.URL))
+		require.NoError(t, logger.Log(record))
+		logger.Close()
+		assert.Len(t, errs, 1)
+	})
+}
@@ -0,0 +1,3 @@
+// audit records all operations done by the controller on owned secrets in a
+// tamper-evident (HMAC-chained) JSON audit log.
+package audit
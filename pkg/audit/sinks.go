@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// StreamSink writes audit records as JSON lines to a stream.
+	StreamSink struct {
+		writer   io.Writer
+		lastHash string
+		mx       sync.Mutex
+	}
+
+	// WebhookSink sends audit records to an HTTP endpoint, with a POST
+	// request per record.
+	WebhookSink struct {
+		url    string
+		client *http.Client
+	}
+)
+
+// NewStreamSink creates a sink writing to the given stream.
+func NewStreamSink(writer io.Writer) *StreamSink { return &StreamSink{writer: writer} }
+
+// OpenFileSink opens (or creates) the given audit log file, verified with
+// the given HMAC key. The sink continues the chain of an existing audit log.
+func OpenFileSink(path string, key []byte) (*StreamSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+
+	lastHash, err := Verify(file, key)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to verify audit log %s: %w", path, err)
+	}
+	return &StreamSink{writer: file, lastHash: lastHash}, nil
+}
+
+// LastHash implements ChainedSink.
+func (s *StreamSink) LastHash() string { return s.lastHash }
+
+// Write implements Sink.
+func (s *StreamSink) Write(record []byte) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, err := s.writer.Write(append(record, '\n'))
+	return err
+}
+
+// NewWebhookSink creates a sink sending records to the given URL.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Write implements Sink.
+func (s *WebhookSink) Write(record []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(record))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
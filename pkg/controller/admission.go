@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thoas/go-funk"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// ValidateSyncAnnotationsPath is the path where the validating webhook
+	// of the synchronization annotations is served.
+	ValidateSyncAnnotationsPath = "/validate-v1-secret-sync-annotations"
+	// ValidateOwnedSecretPath is the path where the validating webhook
+	// protecting owned secrets is served.
+	ValidateOwnedSecretPath = "/validate-v1-owned-secret"
+	// MutateSyncAnnotatorPath is the path where the mutating webhook
+	// stamping the annotator of the synchronization annotations is served.
+	MutateSyncAnnotatorPath = "/mutate-v1-secret-sync-annotator"
+)
+
+// kubernetesControllerUsernames lists the Kubernetes controllers which must
+// always be able to remove owned secrets (garbage collection or namespace
+// removal).
+var kubernetesControllerUsernames = []string{
+	"system:serviceaccount:kube-system:generic-garbage-collector",
+	"system:serviceaccount:kube-system:namespace-controller",
+}
+
+// SyncAnnotationsValidator is an admission handler which rejects all secrets
+// with malformed or conflicting synchronization annotations.
+type SyncAnnotationsValidator struct{ decoder *admission.Decoder }
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *SyncAnnotationsValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (v *SyncAnnotationsValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1beta1.Create && req.Operation != admissionv1beta1.Update {
+		return admission.Allowed("")
+	}
+
+	secret := corev1.Secret{}
+	if err := v.decoder.Decode(req, &secret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if err := ValidateSyncAnnotations(secret); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// OwnedSecretProtector is an admission handler which denies all updates and
+// deletions of owned secrets, except when they come from the controller
+// itself.
+type OwnedSecretProtector struct {
+	// Username is the name of the user used by the controller.
+	Username string
+	decoder  *admission.Decoder
+}
+
+// ServiceAccountUsername returns the username of the given service
+// account, formatted as <namespace>/<name>.
+func ServiceAccountUsername(serviceAccount string) (string, error) {
+	namespace, name, err := parseServiceAccount(serviceAccount)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name), nil
+}
+
+// parseServiceAccount returns the namespace and the name of the given
+// service account, formatted as <namespace>/<name>.
+func parseServiceAccount(serviceAccount string) (namespace, name string, err error) {
+	parts := strings.Split(serviceAccount, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid service account '%s': must be formatted as <namespace>/<name>", serviceAccount)
+	}
+	return parts[0], parts[1], nil
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (p *OwnedSecretProtector) InjectDecoder(decoder *admission.Decoder) error {
+	p.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (p *OwnedSecretProtector) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1beta1.Update && req.Operation != admissionv1beta1.Delete {
+		return admission.Allowed("")
+	}
+	if req.UserInfo.Username == p.Username || funk.ContainsString(kubernetesControllerUsernames, req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+
+	// NOTE: the old object is always provided for updates and deletions
+	secret := corev1.Secret{}
+	if err := p.decoder.DecodeRaw(req.OldObject, &secret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	originName, isOwned := secret.Labels[OriginNameLabelsKey]
+	if !isOwned {
+		return admission.Allowed("")
+	}
+	return admission.Denied(fmt.Sprintf(
+		"secret %s/%s is synchronized by %s; edit the secret %s/%s instead",
+		secret.Namespace, secret.Name, controllerName, secret.Labels[OriginNamespaceLabelsKey], originName,
+	))
+}
+
+// SyncAnnotatorStamper is an admission handler which stamps the user who has
+// last written a secret with synchronization annotations; the synchronized
+// content is then always authorized with the permissions of its last
+// writer. Stamps set by users themselves are always overwritten in order to
+// prevent forgery. Updates done by the controller itself (Username), like
+// the synchronization status, keep the previous stamp.
+type SyncAnnotatorStamper struct {
+	Username string
+	decoder  *admission.Decoder
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (s *SyncAnnotatorStamper) InjectDecoder(decoder *admission.Decoder) error {
+	s.decoder = decoder
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (s *SyncAnnotatorStamper) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1beta1.Create && req.Operation != admissionv1beta1.Update {
+		return admission.Allowed("")
+	}
+
+	secret := corev1.Secret{}
+	if err := s.decoder.Decode(req, &secret); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	old := corev1.Secret{}
+	if req.Operation == admissionv1beta1.Update {
+		if err := s.decoder.DecodeRaw(req.OldObject, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	switch {
+	case !hasSyncAnnotations(secret):
+		delete(secret.Annotations, AnnotatedByAnnotationKey)
+		delete(secret.Annotations, AnnotatedByGroupsAnnotationKey)
+	case req.Operation == admissionv1beta1.Create || req.UserInfo.Username != s.Username:
+		secret.Annotations[AnnotatedByAnnotationKey] = req.UserInfo.Username
+		secret.Annotations[AnnotatedByGroupsAnnotationKey] = strings.Join(req.UserInfo.Groups, ",")
+	default:
+		// NOTE: the secret has been updated by the controller itself; the
+		//       previous stamp is kept
+		for _, key := range []string{AnnotatedByAnnotationKey, AnnotatedByGroupsAnnotationKey} {
+			if value, exists := old.Annotations[key]; exists {
+				secret.Annotations[key] = value
+			} else {
+				delete(secret.Annotations, key)
+			}
+		}
+	}
+
+	raw, err := json.Marshal(secret)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, raw)
+}
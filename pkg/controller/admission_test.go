@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/webhook"
+)
+
+// admissionServer is a local TLS server serving an admission handler, using
+// the self-managed certificates.
+type admissionServer struct {
+	*httptest.Server
+	client *http.Client
+}
+
+func newAdmissionServer(t *testing.T, handler admission.Handler) *admissionServer {
+	certs, err := webhook.GenerateCertificates([]string{"localhost"})
+	require.NoError(t, err)
+	keyPair, err := tls.X509KeyPair(certs.Cert, certs.Key)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certs.CACert))
+
+	hook := &admission.Webhook{Handler: handler}
+	require.NoError(t, hook.InjectScheme(scheme.Scheme))
+	require.NoError(t, hook.InjectLogger(log.NullLogger{}))
+
+	server := httptest.NewUnstartedServer(hook)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return &admissionServer{
+		Server: server,
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}
+}
+
+// Review sends an admission review about the given secret and returns the
+// admission response.
+func (s *admissionServer) Review(t *testing.T, request admissionv1beta1.AdmissionRequest, secret *corev1.Secret) *admissionv1beta1.AdmissionResponse {
+	request.UID = "00000000-0000-0000-0000-000000000000"
+	request.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	if secret != nil {
+		raw, err := json.Marshal(secret)
+		require.NoError(t, err)
+		if request.Operation != admissionv1beta1.Delete {
+			request.Object = runtime.RawExtension{Raw: raw}
+		}
+		if request.Operation != admissionv1beta1.Create && request.OldObject.Raw == nil {
+			request.OldObject = runtime.RawExtension{Raw: raw}
+		}
+	}
+
+	body, err := json.Marshal(admissionv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request:  &request,
+	})
+	require.NoError(t, err)
+
+	// NOTE: the certificate is only valid for 'localhost'
+	url := strings.Replace(s.URL, "127.0.0.1", "localhost", 1)
+	resp, err := s.client.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	review := admissionv1beta1.AdmissionReview{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&review))
+	require.NotNil(t, review.Response)
+	return review.Response
+}
+
+func TestSyncAnnotationsValidator(t *testing.T) {
+	server := newAdmissionServer(t, &SyncAnnotationsValidator{})
+
+	tests := []struct {
+		name        string
+		operation   admissionv1beta1.Operation
+		annotations map[string]string
+		allowed     bool
+		message     string
+	}{
+		{"WithoutAnnotation", admissionv1beta1.Create, nil, true, ""},
+		{"WithAllNamespaces", admissionv1beta1.Create, map[string]string{NamespaceAllAnnotationKey: "true"}, true, ""},
+		{"WithNamespaceSelector", admissionv1beta1.Update, map[string]string{NamespaceSelectorAnnotationKey: "sync=secret"}, true, ""},
+		{
+			"WithInvalidAllNamespaces", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "yes"},
+			false, "'secret.sync.klst.pw/all-namespaces' is not 'true'",
+		},
+		{
+			"WithInvalidNamespaceSelector", admissionv1beta1.Update,
+			map[string]string{NamespaceSelectorAnnotationKey: "sync in ("},
+			false, "failed to parse 'secret.sync.klst.pw/namespace-selector'",
+		},
+		{
+			"WithInvalidConflictPolicy", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", ConflictPolicyAnnotationKey: "overwrite"},
+			false, "'secret.sync.klst.pw/conflict-policy' is invalid: invalid conflict policy 'overwrite'",
+		},
+		{
+			"WithInvalidDeletionPolicy", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", DeletionPolicyAnnotationKey: "retain"},
+			false, "'secret.sync.klst.pw/deletion-policy' is invalid: invalid deletion policy 'retain'",
+		},
+		{
+			"WithInvalidPaused", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", PausedAnnotationKey: "maybe"},
+			false, "'secret.sync.klst.pw/paused' is not a boolean",
+		},
+		{
+			"WithInvalidVersionedName", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", VersionedNameAnnotationKey: "yes"},
+			false, "'secret.sync.klst.pw/versioned-name' is not a boolean",
+		},
+		{
+			"WithInvalidRolloutBatchSize", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", RolloutBatchSizeAnnotationKey: "0"},
+			false, "'secret.sync.klst.pw/rollout-batch-size' is not a positive integer",
+		},
+		{
+			"WithInvalidExpiresAt", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", ExpiresAtAnnotationKey: "tomorrow"},
+			false, "'secret.sync.klst.pw/expires-at' is not a RFC3339 time",
+		},
+		{
+			"WithInvalidVersionHistory", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", VersionHistoryAnnotationKey: "all"},
+			false, "'secret.sync.klst.pw/version-history' is not a positive integer",
+		},
+		{
+			"WithBothAnnotations", admissionv1beta1.Create,
+			map[string]string{NamespaceAllAnnotationKey: "true", NamespaceSelectorAnnotationKey: "sync=secret"},
+			false, "annotation 'secret.sync.klst.pw/all-namespaces' and 'secret.sync.klst.pw/namespace-selector' cannot be used together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret", Annotations: tt.annotations}}
+			resp := server.Review(t, admissionv1beta1.AdmissionRequest{Operation: tt.operation}, secret)
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			if !tt.allowed {
+				require.NotNil(t, resp.Result)
+				assert.Contains(t, resp.Result.Reason, tt.message)
+			}
+		})
+	}
+
+	t.Run("WithDeleteOperation", func(t *testing.T) {
+		resp := server.Review(t, admissionv1beta1.AdmissionRequest{Operation: admissionv1beta1.Delete}, nil)
+		assert.True(t, resp.Allowed)
+	})
+}
+
+func TestOwnedSecretProtector(t *testing.T) {
+	username, err := ServiceAccountUsername("default/sync-secrets-controller")
+	require.NoError(t, err)
+	server := newAdmissionServer(t, &OwnedSecretProtector{Username: username})
+
+	owned := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "kube-public",
+		Name:      "secret",
+		Labels:    map[string]string{OriginNameLabelsKey: "secret", OriginNamespaceLabelsKey: "default"},
+	}}
+	unmanaged := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: "unmanaged"}}
+
+	tests := []struct {
+		name      string
+		operation admissionv1beta1.Operation
+		username  string
+		secret    *corev1.Secret
+		allowed   bool
+	}{
+		{"CreateOwnedSecret", admissionv1beta1.Create, "admin", owned, true},
+		{"UpdateOwnedSecret", admissionv1beta1.Update, "admin", owned, false},
+		{"DeleteOwnedSecret", admissionv1beta1.Delete, "admin", owned, false},
+		{"UpdateUnmanagedSecret", admissionv1beta1.Update, "admin", unmanaged, true},
+		{"DeleteUnmanagedSecret", admissionv1beta1.Delete, "admin", unmanaged, true},
+		{"UpdateOwnedSecretByController", admissionv1beta1.Update, username, owned, true},
+		{"DeleteOwnedSecretByController", admissionv1beta1.Delete, username, owned, true},
+		{"DeleteOwnedSecretByGarbageCollector", admissionv1beta1.Delete, "system:serviceaccount:kube-system:generic-garbage-collector", owned, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := admissionv1beta1.AdmissionRequest{Operation: tt.operation}
+			request.UserInfo.Username = tt.username
+			resp := server.Review(t, request, tt.secret)
+
+			assert.Equal(t, tt.allowed, resp.Allowed)
+			if !tt.allowed {
+				require.NotNil(t, resp.Result)
+				assert.EqualValues(t, "secret kube-public/secret is synchronized by sync-secrets-controller; edit the secret default/secret instead", resp.Result.Reason)
+			}
+		})
+	}
+}
+
+func TestSyncAnnotatorStamper(t *testing.T) {
+	server := newAdmissionServer(t, &SyncAnnotatorStamper{Username: "system:serviceaccount:default:sync-secrets-controller"})
+
+	stamped := map[string]string{
+		NamespaceAllAnnotationKey:      "true",
+		AnnotatedByAnnotationKey:       "alice",
+		AnnotatedByGroupsAnnotationKey: "developers,system:authenticated",
+	}
+
+	tests := []struct {
+		name        string
+		operation   admissionv1beta1.Operation
+		username    string
+		old         map[string]string
+		annotations map[string]string
+		expected    map[string]string
+	}{
+		{"CreateWithoutAnnotation", admissionv1beta1.Create, "", nil, map[string]string{"owner": "alice"}, map[string]string{"owner": "alice"}},
+		{
+			"CreateWithAnnotation", admissionv1beta1.Create, "", nil,
+			map[string]string{NamespaceAllAnnotationKey: "true"},
+			map[string]string{NamespaceAllAnnotationKey: "true", AnnotatedByAnnotationKey: "bob", AnnotatedByGroupsAnnotationKey: "system:authenticated"},
+		},
+		{
+			"CreateWithForgedStamp", admissionv1beta1.Create, "", nil,
+			stamped,
+			map[string]string{NamespaceAllAnnotationKey: "true", AnnotatedByAnnotationKey: "bob", AnnotatedByGroupsAnnotationKey: "system:authenticated"},
+		},
+		{
+			// NOTE: the secret content may have been changed; it must be
+			//       authorized with the permissions of its last writer
+			"UpdateWithoutAnnotationChange", admissionv1beta1.Update, "", stamped,
+			map[string]string{NamespaceAllAnnotationKey: "true", "owner": "bob"},
+			map[string]string{NamespaceAllAnnotationKey: "true", "owner": "bob", AnnotatedByAnnotationKey: "bob", AnnotatedByGroupsAnnotationKey: "system:authenticated"},
+		},
+		{
+			"UpdateByController", admissionv1beta1.Update, "system:serviceaccount:default:sync-secrets-controller", stamped,
+			map[string]string{NamespaceAllAnnotationKey: "true", "owner": "bob"},
+			map[string]string{NamespaceAllAnnotationKey: "true", "owner": "bob", AnnotatedByAnnotationKey: "alice", AnnotatedByGroupsAnnotationKey: "developers,system:authenticated"},
+		},
+		{
+			"UpdateByControllerWithForgedStamp", admissionv1beta1.Update, "system:serviceaccount:default:sync-secrets-controller", stamped,
+			map[string]string{NamespaceAllAnnotationKey: "true", AnnotatedByAnnotationKey: "admin"},
+			stamped,
+		},
+		{
+			"UpdateWithForgedStamp", admissionv1beta1.Update, "", stamped,
+			map[string]string{NamespaceAllAnnotationKey: "true", AnnotatedByAnnotationKey: "admin"},
+			map[string]string{NamespaceAllAnnotationKey: "true", AnnotatedByAnnotationKey: "bob", AnnotatedByGroupsAnnotationKey: "system:authenticated"},
+		},
+		{
+			"UpdateWithAnnotationChange", admissionv1beta1.Update, "", stamped,
+			map[string]string{NamespaceSelectorAnnotationKey: "sync=secret", AnnotatedByAnnotationKey: "alice"},
+			map[string]string{NamespaceSelectorAnnotationKey: "sync=secret", AnnotatedByAnnotationKey: "bob", AnnotatedByGroupsAnnotationKey: "system:authenticated"},
+		},
+		{
+			"UpdateWithAnnotationRemoved", admissionv1beta1.Update, "", stamped,
+			map[string]string{AnnotatedByAnnotationKey: "alice", AnnotatedByGroupsAnnotationKey: "developers"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := admissionv1beta1.AdmissionRequest{Operation: tt.operation}
+			request.UserInfo.Username = "bob"
+			request.UserInfo.Groups = []string{"system:authenticated"}
+			if tt.username != "" {
+				request.UserInfo.Username = tt.username
+			}
+			if tt.old != nil {
+				raw, err := json.Marshal(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret", Annotations: tt.old}})
+				require.NoError(t, err)
+				request.OldObject = runtime.RawExtension{Raw: raw}
+			}
+
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret", Annotations: tt.annotations}}
+			resp := server.Review(t, request, secret)
+			require.True(t, resp.Allowed)
+
+			raw, err := json.Marshal(secret)
+			require.NoError(t, err)
+			if len(resp.Patch) > 0 {
+				patch, err := jsonpatch.DecodePatch(resp.Patch)
+				require.NoError(t, err)
+				raw, err = patch.Apply(raw)
+				require.NoError(t, err)
+			}
+
+			actual := corev1.Secret{}
+			require.NoError(t, json.Unmarshal(raw, &actual))
+			if len(tt.expected) == 0 {
+				assert.Empty(t, actual.Annotations)
+			} else {
+				assert.Equal(t, tt.expected, actual.Annotations)
+			}
+		})
+	}
+}
+
+func TestServiceAccountUsername(t *testing.T) {
+	username, err := ServiceAccountUsername("default/sync-secrets-controller")
+	assert.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:default:sync-secrets-controller", username)
+
+	_, err = ServiceAccountUsername("sync-secrets-controller")
+	assert.EqualError(t, err, "invalid service account 'sync-secrets-controller': must be formatted as <namespace>/<name>")
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/audit"
+)
+
+// newAuditLogger creates the audit logger writing to the given file ('-'
+// for stdout) and sending records to the given webhook URL, signed with the
+// HMAC key read from the given key file.
+func newAuditLogger(path, webhookURL, keyFile string) (*audit.Logger, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("an audit key file is required to sign the audit records")
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key file %s: %w", keyFile, err)
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("audit key file %s is empty", keyFile)
+	}
+
+	var sinks []audit.Sink
+	switch path {
+	case "":
+	case "-":
+		sinks = append(sinks, audit.NewStreamSink(os.Stdout))
+	default:
+		sink, err := audit.OpenFileSink(path, key)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if webhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(webhookURL))
+	}
+	return audit.New(key, func(err error) {
+		droppedAuditRecordsTotal.WithLabelValues("write_failed").Inc()
+		klog.Error(err)
+	}, sinks...), nil
+}
+
+// auditSource returns the managed secret of the given owned secret, based on
+// its origin labels and its owner reference.
+func auditSource(secret *corev1.Secret) audit.Object {
+	source := audit.Object{
+		Namespace: secret.Labels[OriginNamespaceLabelsKey],
+		Name:      secret.Labels[OriginNameLabelsKey],
+	}
+	if len(secret.OwnerReferences) > 0 {
+		source.UID = secret.OwnerReferences[0].UID
+	}
+	return source
+}
+
+// auditOperation records the given operation, done on an owned secret of the
+// given managed secret, in the audit log. Only the key names and the hash of
+// the owned secret are recorded, never its values.
+func auditOperation(ctx *Context, operation, reason string, source audit.Object, secret *corev1.Secret, err error) {
+	if ctx.auditor == nil || err != nil {
+		return
+	}
+
+	record := audit.Record{
+		Operation: operation,
+		Reason:    reason,
+		Source:    source,
+		Target:    audit.Object{Namespace: secret.Namespace, Name: secret.Name, UID: secret.UID},
+		Cluster:   ctx.cluster,
+	}
+
+	// NOTE: the content of a deleted owned secret is unknown; only the
+	//       content of written owned secrets is recorded
+	if operation != deleteOperation {
+		for key := range secret.Data {
+			record.Keys = append(record.Keys, key)
+		}
+		sort.Strings(record.Keys)
+		record.SecretHash = secret.Annotations[SourceHashAnnotationKey]
+	}
+
+	if err := ctx.auditor.Log(record); err != nil {
+		droppedAuditRecordsTotal.WithLabelValues("queue_full").Inc()
+		klog.Errorf("failed to audit %s of %T %s/%s: %s", operation, secret, secret.Namespace, secret.Name, err)
+	}
+}
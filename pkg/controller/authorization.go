@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// annotator annotations, stamped by the admission webhook with the user
+	// who has set the synchronization annotations
+	AnnotatedByAnnotationKey       = "secret.sync.klst.pw/annotated-by"
+	AnnotatedByGroupsAnnotationKey = "secret.sync.klst.pw/annotated-by-groups"
+)
+
+type (
+	// Authorizer checks if a user is allowed to create secrets in a
+	// specific namespace.
+	Authorizer interface {
+		CanCreateSecrets(ctx *Context, username string, groups []string, namespace string) (bool, error)
+	}
+
+	// subjectAccessReviewAuthorizer implements Authorizer with
+	// SubjectAccessReview.
+	subjectAccessReviewAuthorizer struct{ client client.Client }
+
+	// cachedAuthorizer implements Authorizer by caching the decisions of
+	// another Authorizer for a while, per user and namespace; all secrets
+	// are reviewed on every resync, which would otherwise send a review per
+	// secret and namespace.
+	cachedAuthorizer struct {
+		Authorizer
+		ttl time.Duration
+
+		decisions map[authorizationKey]authorizationDecision
+		mx        sync.Mutex
+	}
+	authorizationKey struct {
+		username, groups, namespace string
+	}
+	authorizationDecision struct {
+		allowed   bool
+		expiresAt time.Time
+	}
+)
+
+// authorizationCacheTTL is the duration during which an authorization
+// decision is kept.
+const authorizationCacheTTL = time.Minute
+
+func newCachedAuthorizer(authorizer Authorizer, ttl time.Duration) *cachedAuthorizer {
+	return &cachedAuthorizer{
+		Authorizer: authorizer,
+		ttl:        ttl,
+		decisions:  map[authorizationKey]authorizationDecision{},
+	}
+}
+
+// CanCreateSecrets implements Authorizer.
+func (a subjectAccessReviewAuthorizer) CanCreateSecrets(ctx *Context, username string, groups []string, namespace string) (bool, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   username,
+			Groups: groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "create",
+				Version:   "v1",
+				Resource:  "secrets",
+			},
+		},
+	}
+	if err := a.client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// CanCreateSecrets implements Authorizer.
+func (a *cachedAuthorizer) CanCreateSecrets(ctx *Context, username string, groups []string, namespace string) (bool, error) {
+	key := authorizationKey{username: username, groups: strings.Join(groups, ","), namespace: namespace}
+	now := time.Now()
+
+	a.mx.Lock()
+	decision, exists := a.decisions[key]
+	a.mx.Unlock()
+	if exists && now.Before(decision.expiresAt) {
+		return decision.allowed, nil
+	}
+
+	allowed, err := a.Authorizer.CanCreateSecrets(ctx, username, groups, namespace)
+	if err != nil {
+		return false, err
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	// NOTE: expired decisions are dropped here, in order to keep the cache
+	//       bounded to the decisions of the last TTL
+	for key, decision := range a.decisions {
+		if !now.Before(decision.expiresAt) {
+			delete(a.decisions, key)
+		}
+	}
+	a.decisions[key] = authorizationDecision{allowed: allowed, expiresAt: now.Add(a.ttl)}
+	return allowed, nil
+}
+
+// filterAuthorizedNamespaces returns only the namespaces where the user who
+// has annotated the given secret is allowed to create secrets.
+func filterAuthorizedNamespaces(ctx *Context, secret corev1.Secret, namespaces []string) ([]string, error) {
+	username := secret.Annotations[AnnotatedByAnnotationKey]
+	if username == "" {
+		return filterExistingNamespaces(ctx, secret, namespaces), nil
+	}
+
+	var groups []string
+	if annotation := secret.Annotations[AnnotatedByGroupsAnnotationKey]; annotation != "" {
+		groups = strings.Split(annotation, ",")
+	}
+
+	authorized := make([]string, 0, len(namespaces))
+	var denied []string
+	for _, namespace := range namespaces {
+		allowed, err := ctx.authorizer.CanCreateSecrets(ctx, username, groups, namespace)
+		if err != nil {
+			return nil, ClientError{fmt.Errorf("failed to review access of %s on namespace %s: %w", username, namespace, err)}
+		}
+
+		if !allowed {
+			klog.V(3).Infof("%s cannot create secrets in namespace %s, ignore synchronization of %T %s/%s", username, namespace, secret, secret.Namespace, secret.Name)
+			denied = append(denied, namespace)
+			continue
+		}
+		authorized = append(authorized, namespace)
+	}
+
+	if len(denied) > 0 {
+		ctx.recorder.Eventf(&secret, corev1.EventTypeWarning, AnnotatorUnauthorizedReason, "%s is not allowed to create secrets in namespace(s) %s", username, strings.Join(denied, ", "))
+	}
+	return authorized, nil
+}
+
+// filterExistingNamespaces returns only the namespaces where the given
+// secret, not stamped with its annotator, already has owned secrets. These
+// secrets have been annotated before the annotator authorization was enabled;
+// their owned secrets are kept, but never created in other namespaces, until
+// they are stamped.
+func filterExistingNamespaces(ctx *Context, secret corev1.Secret, namespaces []string) []string {
+	existing := map[string]bool{}
+	for _, owned := range ctx.registry.OwnedSecretsWithUID(secret.UID) {
+		existing[owned.Namespace] = true
+	}
+
+	kept := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if existing[namespace] {
+			kept = append(kept, namespace)
+		}
+	}
+	ctx.recorder.Eventf(&secret, corev1.EventTypeWarning, AnnotatorUnauthorizedReason, "Annotation '%s' not found, secret is not synchronized into new namespaces until it is updated", AnnotatedByAnnotationKey)
+	return kept
+}
+
+// isRemoteSyncDenied returns true if the user who has annotated the given
+// secret is not allowed to synchronize it on remote clusters. The annotator
+// authorization cannot be checked on the remote clusters, so the annotator
+// must be allowed to create secrets in the remote clusters namespace, like
+// the kubeconfig secrets defining the remote clusters.
+func isRemoteSyncDenied(ctx *Context, secret corev1.Secret) (bool, error) {
+	if _, exists := secret.Annotations[RemoteClustersAnnotationKey]; !exists {
+		return false, nil
+	}
+
+	authorized, err := filterAuthorizedNamespaces(ctx, secret, []string{ctx.RemoteClustersNamespace})
+	if err != nil {
+		return false, err
+	}
+	return len(authorized) == 0, nil
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingAuthorizer is an Authorizer which counts the reviews.
+type countingAuthorizer struct{ reviews int }
+
+func (a *countingAuthorizer) CanCreateSecrets(_ *Context, username string, _ []string, namespace string) (bool, error) {
+	a.reviews++
+	return username == "alice" && namespace == "team-a", nil
+}
+
+func TestCachedAuthorizer(t *testing.T) {
+	ctx := NewTestContext(context.TODO(), nil, nil)
+	reviews := &countingAuthorizer{}
+	authorizer := newCachedAuthorizer(reviews, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		allowed, err := authorizer.CanCreateSecrets(ctx, "alice", []string{"developers"}, "team-a")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		allowed, err = authorizer.CanCreateSecrets(ctx, "alice", []string{"developers"}, "team-b")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	}
+	assert.Equal(t, 2, reviews.reviews)
+
+	// NOTE: decisions are cached per user (and groups) and namespace
+	_, _ = authorizer.CanCreateSecrets(ctx, "alice", nil, "team-a")
+	assert.Equal(t, 3, reviews.reviews)
+
+	time.Sleep(60 * time.Millisecond)
+	_, _ = authorizer.CanCreateSecrets(ctx, "alice", []string{"developers"}, "team-a")
+	assert.Equal(t, 4, reviews.reviews)
+	assert.Len(t, authorizer.decisions, 1)
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// bootstrapRegistry fills the registry with all managed and owned secrets
+// already present in the cluster. This allows the owned secrets to be
+// restored right after a restart of the controller, without waiting the
+// reconciliation of their managed secret.
+func bootstrapRegistry(ctx *Context) error {
+	secrets := &corev1.SecretList{}
+	if err := ctx.client.List(ctx, secrets); err != nil {
+		return ClientError{fmt.Errorf("failed to list secrets: %w", err)}
+	}
+
+	// NOTE: denied secrets are not registered, so their owned secrets are
+	//       never restored; they are pruned by the reconciliation of the
+	//       denied secret
+	quiet := *ctx
+	quiet.recorder = discardRecorder{}
+	for _, secret := range secrets.Items {
+		if len(secret.OwnerReferences) > 0 || !hasSyncAnnotations(secret) {
+			continue
+		}
+		if _, denied := checkSourcePolicy(&quiet, secret).(PolicyError); denied {
+			klog.V(3).Infof("ignore denied %T %s/%s", secret, secret.Namespace, secret.Name)
+			continue
+		}
+
+		name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+		if err := ctx.registry.RegisterSecret(name, secret.UID); err != nil {
+			klog.Errorf("failed to register %T %s: %s", secret, name, err)
+		}
+	}
+
+	// NOTE: owned secrets are sorted by creation in order to register the
+	//       versions of the owned secrets from the oldest to the most recent
+	sort.SliceStable(secrets.Items, func(i, j int) bool {
+		return secrets.Items[i].CreationTimestamp.Before(&secrets.Items[j].CreationTimestamp)
+	})
+	for _, secret := range secrets.Items {
+		origin, isOwned := secret.Labels[OriginNameLabelsKey]
+		if !isOwned || len(secret.OwnerReferences) == 0 {
+			continue
+		}
+
+		// NOTE: managed secrets don't exist on remote clusters; they are
+		//       registered from the origin labels of their owned secrets
+		if ctx.cluster != "" && ctx.registry.SecretWithUID(secret.OwnerReferences[0].UID) == nil {
+			owner := types.NamespacedName{Namespace: secret.Labels[OriginNamespaceLabelsKey], Name: origin}
+			if err := ctx.registry.RegisterSecret(owner, secret.OwnerReferences[0].UID); err != nil {
+				klog.Errorf("failed to register %T %s: %s", secret, owner, err)
+			}
+		}
+
+		name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+		if err := ctx.registry.RegisterOwnedSecret(secret.OwnerReferences[0].UID, name); err != nil {
+			klog.V(3).Infof("ignore owned %T %s: %s", secret, name, err)
+			continue
+		}
+
+		// NOTE: versions can only be rebuilt from their owned secrets, which
+		//       allows them to be restored after a restart. Only intact
+		//       secrets, named after their hash, are trusted as versions
+		uid := secret.OwnerReferences[0].UID
+		if isIntactVersion(secret, origin) && ctx.registry.VersionWithName(uid, secret.Name) == nil {
+			ctx.registry.RegisterVersion(uid, versionTemplate(secret))
+		}
+	}
+
+	klog.V(1).Infof("registry bootstrapped with %d managed secrets", len(ctx.registry.Secrets()))
+	return nil
+}
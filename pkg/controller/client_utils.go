@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/audit"
+)
+
+// createOwnedSecret creates the given owned secret and records the operation,
+// done for the given reason. The operation is only reported when the
+// synchronization is simulated (dry-run); it is the same for all
+// operations on owned secrets.
+func createOwnedSecret(ctx *Context, secret *corev1.Secret, reason string) error {
+	if ctx.simulation {
+		simulateOperation(ctx, createOperation, reason, secret)
+		return nil
+	}
+
+	start := time.Now()
+	err := ctx.client.Create(ctx, secret)
+	observeOperation(createOperation, secret.Namespace, start, err)
+	auditOperation(ctx, createOperation, reason, auditSource(secret), secret, err)
+	return err
+}
+
+// updateOwnedSecret updates the given owned secret and records the operation,
+// done for the given reason.
+func updateOwnedSecret(ctx *Context, secret *corev1.Secret, reason string) error {
+	return updateOwnedSecretOf(ctx, audit.Object{}, secret, reason)
+}
+
+// updateOwnedSecretOf updates the given owned secret of the given managed
+// secret and records the operation, done for the given reason. The managed
+// secret is read from the owned secret if empty; it must be given when the
+// owned secret no longer references it (when it is orphaned).
+func updateOwnedSecretOf(ctx *Context, source audit.Object, secret *corev1.Secret, reason string) error {
+	if ctx.simulation {
+		simulateOperation(ctx, updateOperation, reason, secret)
+		return nil
+	}
+	if source == (audit.Object{}) {
+		source = auditSource(secret)
+	}
+
+	start := time.Now()
+	err := ctx.client.Update(ctx, secret)
+	observeOperation(updateOperation, secret.Namespace, start, err)
+	auditOperation(ctx, updateOperation, reason, source, secret, err)
+	return err
+}
+
+// deleteOwnedSecret deletes the given owned secret and records the operation,
+// done for the given reason.
+func deleteOwnedSecret(ctx *Context, secret *corev1.Secret, reason string) error {
+	if ctx.simulation {
+		simulateOperation(ctx, deleteOperation, reason, secret)
+		return nil
+	}
+
+	start := time.Now()
+	err := ctx.client.Delete(ctx, secret)
+	observeOperation(deleteOperation, secret.Namespace, start, err)
+	auditOperation(ctx, deleteOperation, reason, auditSource(secret), secret, err)
+	return err
+}
+
+// replaceOwnedSecret replaces the given live owned secret by the desired
+// one, by deleting and creating it again. It is used when the live owned
+// secret cannot be updated (immutable secret).
+func replaceOwnedSecret(ctx *Context, secret, desired *corev1.Secret, reason string) error {
+	if err := deleteOwnedSecret(ctx, secret, reason); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return createOwnedSecret(ctx, desired, reason)
+}
+
+// listOwnedSecrets lists all owned secrets of the given managed secret, based
+// on their origin labels and their owner reference. Unlike the registry, it
+// also finds the owned secrets which are not registered yet.
+func listOwnedSecrets(ctx *Context, owner corev1.Secret) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+	err := ctx.client.List(ctx, secrets, client.MatchingLabels{OriginNamespaceLabelsKey: owner.Namespace, OriginNameLabelsKey: owner.Name})
+	if err != nil {
+		return nil, ClientError{fmt.Errorf("failed to list owned secrets of %T %s/%s: %w", owner, owner.Namespace, owner.Name, err)}
+	}
+
+	owned := make([]corev1.Secret, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if len(secret.OwnerReferences) > 0 && secret.OwnerReferences[0].UID == owner.UID {
+			owned = append(owned, secret)
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ConflictPolicyAnnotationKey is the annotation defining how the controller
+// handles pre-existing secrets which are not owned by the managed secret.
+const ConflictPolicyAnnotationKey = "secret.sync.klst.pw/conflict-policy"
+
+// conflict policies
+const (
+	// SkipConflictPolicy ignores the conflicting secret.
+	SkipConflictPolicy = "skip"
+	// AdoptConflictPolicy takes over and overwrites the conflicting secret.
+	AdoptConflictPolicy = "adopt"
+	// AdoptIfIdenticalConflictPolicy takes over the conflicting secret
+	// only if its content is identical.
+	AdoptIfIdenticalConflictPolicy = "adopt-if-identical"
+	// FailConflictPolicy fails the synchronization.
+	FailConflictPolicy = "fail"
+)
+
+// ConflictPolicies lists all valid conflict policies.
+var ConflictPolicies = []string{SkipConflictPolicy, AdoptConflictPolicy, AdoptIfIdenticalConflictPolicy, FailConflictPolicy}
+
+// ValidateConflictPolicy validates the given conflict policy.
+func ValidateConflictPolicy(policy string) error {
+	if !funk.ContainsString(ConflictPolicies, policy) {
+		return fmt.Errorf("invalid conflict policy '%s': must be one of %v", policy, ConflictPolicies)
+	}
+	return nil
+}
+
+// enqueueConflictOwner enqueues the managed secret in conflict with the
+// given secret, so the conflict is resolved again as soon as the conflicting
+// secret is updated or removed (the 'fail' conflict policy is never
+// requeued).
+func enqueueConflictOwner(ctx *Context) *handler.EnqueueRequestsFromMapFunc {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			name := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}
+			if owner := ctx.registry.SecretWithConflictName(name); owner != nil {
+				return []reconcile.Request{{NamespacedName: owner.NamespacedName}}
+			}
+			return nil
+		}),
+	}
+}
+
+// conflictPolicy returns the conflict policy of the given managed secret;
+// the controller default policy is used if the secret doesn't define it.
+func conflictPolicy(ctx *Context, secret corev1.Secret) string {
+	if policy, exists := secret.Annotations[ConflictPolicyAnnotationKey]; exists {
+		return policy
+	}
+	if ctx.ConflictPolicy == "" {
+		return SkipConflictPolicy
+	}
+	return ctx.ConflictPolicy
+}
+
+// resolveConflict applies the conflict policy of the owner on the given
+// conflicting secret and reports the outcome. It returns true if the
+// conflicting secret has been adopted and must be overwritten, or an error
+// if the synchronization must fail.
+func resolveConflict(ctx *Context, owner corev1.Secret, secret, template *corev1.Secret) (bool, error) {
+	ownerName := types.NamespacedName{Namespace: owner.Namespace, Name: owner.Name}
+	name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	policy := conflictPolicy(ctx, owner)
+
+	// NOTE: only unmanaged secrets can be adopted; secrets owned by someone
+	//       else are always in conflict
+	_, hasOrigin := secret.Labels[OriginNameLabelsKey]
+	unmanaged := len(secret.OwnerReferences) == 0 && !hasOrigin
+
+	switch {
+	case unmanaged && policy == AdoptConflictPolicy,
+		unmanaged && policy == AdoptIfIdenticalConflictPolicy && hasSameContent(secret, template):
+		klog.V(1).Infof("adopt %T %s with conflict policy %s", secret, name, policy)
+		_ = ctx.registry.UnregisterConflict(name)
+		ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, AdoptedReason, "Secret %s adopted (conflict policy '%s')", name, policy)
+		ctx.recorder.Eventf(secret, corev1.EventTypeNormal, AdoptedReason, "Secret adopted by %s (conflict policy '%s')", ownerName, policy)
+		return true, nil
+	}
+
+	klog.V(0).Infof("secret %s not owned by %T %s... ignore (conflict policy %s)", name, secret, ownerName, policy)
+	_ = ctx.registry.RegisterConflict(owner.UID, name)
+	ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, NameConflictReason, "Secret %s already exists and is not owned by this secret (conflict policy '%s')", name, policy)
+	ctx.recorder.Eventf(secret, corev1.EventTypeWarning, NameConflictReason, "Secret cannot be synchronized from %s: not owned by it", ownerName)
+
+	if policy == FailConflictPolicy {
+		return false, PolicyError{fmt.Errorf("secret %s already exists and is not owned by this secret", name)}
+	}
+	return false, nil
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+func TestEnqueueConflictOwner(t *testing.T) {
+	ctx := NewTestContext(context.TODO(), fake.NewFakeClientWithScheme(scheme.Scheme), registry.New())
+	owner := types.NamespacedName{Namespace: "default", Name: "secret"}
+	require.NoError(t, ctx.registry.RegisterSecret(owner, "6f9f8a9e-0bfa-4ec1-a8d5-9c9b8f2f8c61"))
+	require.NoError(t, ctx.registry.RegisterConflict("6f9f8a9e-0bfa-4ec1-a8d5-9c9b8f2f8c61", types.NamespacedName{Namespace: "team-a", Name: "secret"}))
+
+	toRequests := enqueueConflictOwner(ctx).ToRequests
+	mapObject := func(namespace, name string) handler.MapObject {
+		meta := &metav1.ObjectMeta{Namespace: namespace, Name: name}
+		return handler.MapObject{Meta: meta}
+	}
+
+	t.Run("WithConflictingSecret", func(t *testing.T) {
+		assert.Equal(t, []reconcile.Request{{NamespacedName: owner}}, toRequests.Map(mapObject("team-a", "secret")))
+	})
+	t.Run("WithoutConflict", func(t *testing.T) {
+		assert.Empty(t, toRequests.Map(mapObject("team-b", "secret")))
+	})
+}
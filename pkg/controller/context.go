@@ -3,8 +3,11 @@ package controller
 import (
 	gocontext "context"
 
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/xunleii/sync-secrets-controller/pkg/audit"
 	"github.com/xunleii/sync-secrets-controller/pkg/registry"
 )
 
@@ -13,29 +16,99 @@ type (
 	// synchronize secrets, like kubernetes client or controller configuration.
 	Context struct {
 		gocontext.Context
-		IgnoredNamespaces    []string
-		ProtectedLabels      []string
-		ProtectedAnnotations []string
+		// IgnoredNamespaces lists the glob patterns of the namespaces
+		// ignored by the controller.
+		IgnoredNamespaces []string
+		// IgnoredNamespaceSelector selects the namespaces ignored by the
+		// controller (no namespace if nil).
+		IgnoredNamespaceSelector labels.Selector
+		ProtectedLabels          []string
+		ProtectedAnnotations     []string
+		// AuthorizeAnnotator restricts the synchronization to the
+		// namespaces where the user who has annotated the secret is
+		// allowed to create secrets.
+		AuthorizeAnnotator bool
+		// DeniedSecretTypes lists the secret types which must never be
+		// synchronized.
+		DeniedSecretTypes []string
+		// SourceNamespaces lists the glob patterns of the namespaces
+		// allowed to publish synchronized secrets (all namespaces if
+		// empty and without SourceNamespaceSelector).
+		SourceNamespaces []string
+		// SourceNamespaceSelector selects the namespaces allowed to
+		// publish synchronized secrets.
+		SourceNamespaceSelector labels.Selector
+		// Paused stops all writes on owned secrets, until the controller
+		// is restarted without it.
+		Paused bool
+		// DryRun only reports the operations which would be done on owned
+		// secrets, without writing anything.
+		DryRun bool
+		// ConflictPolicy is the default conflict policy, used when a
+		// secret doesn't define its own.
+		ConflictPolicy string
+		// WatchNamespaces restricts the controller to the given namespaces
+		// (namespace-scoped mode); all namespaces are watched if empty.
+		WatchNamespaces []string
+		// RemoteClustersNamespace is the namespace of the kubeconfig
+		// secrets defining the remote clusters; the synchronization on
+		// remote clusters is disabled if empty.
+		RemoteClustersNamespace string
 
-		client   client.Client
-		registry *registry.Registry
+		client client.Client
+		// namespaceReader is used to read namespaces; in namespace-scoped
+		// mode, namespaces are not cached and must be read directly from
+		// the API server.
+		namespaceReader client.Reader
+		// workloadReader is used to read the workloads restarted by the
+		// rollout on change; they are never cached, in order to not watch
+		// all workloads of the cluster.
+		workloadReader client.Reader
+		recorder       record.EventRecorder
+		authorizer     Authorizer
+		auditor        *audit.Logger
+		registry       *registry.Registry
+		// rollouts keeps the pending rollouts of the workloads using the
+		// owned secrets.
+		rollouts *pendingRollouts
+		// simulation is set on the contexts used to simulate the
+		// synchronization of a secret (dry-run).
+		simulation bool
+		// remoteClusters contains the remote clusters where the secrets
+		// are also synchronized.
+		remoteClusters *remoteClusters
+		// cluster is the name of the remote cluster targeted by the context
+		// (empty for the local cluster) and clusterNamespaceSelector
+		// selects its namespaces, instead of the secret annotations.
+		cluster                  string
+		clusterNamespaceSelector labels.Selector
 	}
 )
 
 // NewContext creates a new context instance.
 func NewContext(ctx gocontext.Context, client client.Client) *Context {
 	return &Context{
-		Context:  ctx,
-		client:   client,
-		registry: registry.New(),
+		Context:         ctx,
+		client:          client,
+		namespaceReader: client,
+		workloadReader:  client,
+		recorder:        discardRecorder{},
+		authorizer:      subjectAccessReviewAuthorizer{client},
+		registry:        registry.New(),
+		rollouts:        newPendingRollouts(),
 	}
 }
 
 // NewTestContext creates a new context instance for testing purpose.
 func NewTestContext(ctx gocontext.Context, client client.Client, registry *registry.Registry) *Context {
 	return &Context{
-		Context:  ctx,
-		client:   client,
-		registry: registry,
+		Context:         ctx,
+		client:          client,
+		namespaceReader: client,
+		workloadReader:  client,
+		recorder:        discardRecorder{},
+		authorizer:      subjectAccessReviewAuthorizer{client},
+		registry:        registry,
+		rollouts:        newPendingRollouts(),
 	}
 }
@@ -2,87 +2,283 @@ package controller
 
 import (
 	"context"
-	"net/http"
+	"fmt"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	kconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	"github.com/thoas/go-funk"
 	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+	"github.com/xunleii/sync-secrets-controller/pkg/webhook"
 )
 
-const requeueAfter = 5 * time.Second
+const (
+	controllerName = "sync-secrets-controller"
+	requeueAfter   = 5 * time.Second
+)
+
+// closedChannel is used to check the state of the informer caches
+// without waiting.
+var closedChannel = func() chan struct{} { c := make(chan struct{}); close(c); return c }()
 
 type (
 	Controller struct {
 		Context
-		metricsBindAddress     string
-		healthProbeBindAddress string
+		Options
+	}
+
+	// Options contains all options used to configure the controller manager.
+	Options struct {
+		MetricsBindAddress      string
+		HealthProbeBindAddress  string
+		LeaderElection          bool
+		LeaderElectionNamespace string
+		// StuckWorkqueueTimeout is the maximum duration without progress
+		// while items are pending in a workqueue, before the controller is
+		// considered as not alive.
+		StuckWorkqueueTimeout time.Duration
+		// Webhook contains the options of the admission webhook server.
+		Webhook webhook.Options
+		// ProtectOwnedSecrets enables the admission webhook denying manual
+		// updates and deletions of owned secrets.
+		ProtectOwnedSecrets bool
+		// ServiceAccount is the service account used by the controller,
+		// formatted as <namespace>/<name>.
+		ServiceAccount string
+		// AuditLog is the file where the audit log is written ('-' for
+		// stdout) and AuditWebhookURL is the URL where audit records are
+		// sent; the audit log is disabled if both are empty.
+		AuditLog        string
+		AuditWebhookURL string
+		// AuditKeyFile is the file containing the HMAC key signing the
+		// audit records; it is required by the audit log.
+		AuditKeyFile string
+		// ResyncPeriod is the period of the full resync of all managed
+		// secrets, which detects and fixes drifted owned secrets; the
+		// periodic resync is disabled if zero.
+		ResyncPeriod time.Duration
 	}
 )
 
-func NewController(metricsBindAddress, healthProbeBindAddress string, ctx Context) *Controller {
+func NewController(opts Options, ctx Context) *Controller {
 	ctx.Context = context.TODO()
+	ctx.recorder = discardRecorder{}
 	ctx.registry = registry.New()
+	ctx.rollouts = newPendingRollouts()
+	registerRegistryMetrics(ctx.registry)
 
 	return &Controller{
-		Context:                ctx,
-		metricsBindAddress:     metricsBindAddress,
-		healthProbeBindAddress: healthProbeBindAddress,
+		Context: ctx,
+		Options: opts,
 	}
 }
 
 func (c *Controller) Run(stop <-chan struct{}) {
 	mgr, err := manager.New(kconfig.GetConfigOrDie(), manager.Options{
-		MetricsBindAddress:     c.metricsBindAddress,
-		HealthProbeBindAddress: c.healthProbeBindAddress,
-		ReadinessEndpointName:  "/-/readyz",
-		LivenessEndpointName:   "/-/healthz",
+		MetricsBindAddress:      c.MetricsBindAddress,
+		HealthProbeBindAddress:  c.HealthProbeBindAddress,
+		ReadinessEndpointName:   "/-/readyz",
+		LivenessEndpointName:    "/-/healthz",
+		LeaderElection:          c.LeaderElection,
+		LeaderElectionNamespace: c.LeaderElectionNamespace,
+		LeaderElectionID:        controllerName,
+		Port:                    c.Webhook.Port,
+		CertDir:                 c.Webhook.CertDir,
+		NewCache:                newCacheFunc(c.WatchNamespaces),
 	})
 	if err != nil {
 		klog.Fatalf("Unable to set up overall controller manager: %s", err)
 	}
+	if len(c.WatchNamespaces) > 0 && (c.Webhook.Enabled() || c.AuthorizeAnnotator) {
+		klog.Warning("namespace-scoped mode enabled with the webhook server or the annotator authorization; both require cluster-wide permissions")
+	}
+	if len(c.WatchNamespaces) > 0 && c.RemoteClustersNamespace != "" && !funk.ContainsString(c.WatchNamespaces, c.RemoteClustersNamespace) {
+		klog.Warningf("remote clusters namespace %s is not watched; remote clusters will not be loaded", c.RemoteClustersNamespace)
+	}
 	c.Context.client = mgr.GetClient()
+	c.Context.namespaceReader = mgr.GetClient()
+	c.Context.workloadReader = mgr.GetAPIReader()
+	if len(c.WatchNamespaces) > 0 {
+		// NOTE: namespaces cannot be watched with namespace-scoped
+		//       permissions; they are read directly from the API server
+		c.Context.namespaceReader = mgr.GetAPIReader()
+	}
+	c.Context.recorder = mgr.GetEventRecorderFor(controllerName)
+	c.Context.authorizer = newCachedAuthorizer(subjectAccessReviewAuthorizer{mgr.GetClient()}, authorizationCacheTTL)
+	if c.AuditLog != "" || c.AuditWebhookURL != "" {
+		c.Context.auditor, err = newAuditLogger(c.AuditLog, c.AuditWebhookURL, c.AuditKeyFile)
+		if err != nil {
+			klog.Fatalf("Unable to set up audit log: %s", err)
+		}
+	}
+
+	if c.RemoteClustersNamespace != "" {
+		c.Context.remoteClusters = newRemoteClusters(newRemoteClient)
+	}
+
+	probes := newHealthProbes(
+		func() bool { return mgr.GetCache().WaitForCacheSync(closedChannel) },
+		metrics.Registry,
+		c.StuckWorkqueueTimeout,
+	)
+	if c.Webhook.Enabled() {
+		if err := webhook.Setup(mgr, c.Webhook); err != nil {
+			klog.Fatalf("Unable to set up webhook server: %s", err)
+		}
+		mgr.GetWebhookServer().Register(ValidateSyncAnnotationsPath, &admission.Webhook{Handler: &SyncAnnotationsValidator{}})
+
+		username, err := ServiceAccountUsername(c.ServiceAccount)
+		if err != nil && (c.AuthorizeAnnotator || c.ProtectOwnedSecrets) {
+			klog.Fatalf("Unable to set up webhook server: %s", err)
+		}
+
+		if c.AuthorizeAnnotator {
+			mgr.GetWebhookServer().Register(MutateSyncAnnotatorPath, &admission.Webhook{Handler: &SyncAnnotatorStamper{Username: username}})
+		}
+
+		if c.ProtectOwnedSecrets {
+			mgr.GetWebhookServer().Register(ValidateOwnedSecretPath, &admission.Webhook{Handler: &OwnedSecretProtector{Username: username}})
+		}
+	} else if c.AuthorizeAnnotator {
+		// NOTE: without the webhook server, the annotator annotations are
+		//       not stamped and can be forged by anyone able to edit secrets
+		klog.Fatalf("Unable to set up annotator authorization: the webhook server is required, annotator annotations could be forged without it")
+	}
+
+	_ = mgr.AddReadyzCheck("readyz", probes.Readiness)
+	_ = mgr.AddHealthzCheck("healthz", probes.Liveness)
 
-	_ = mgr.AddReadyzCheck("readyz", func(req *http.Request) error { return nil })
-	_ = mgr.AddHealthzCheck("healthz", func(req *http.Request) error { return nil })
+	// NOTE: these runnables require the leader election; they are only
+	//       started when the controller is elected
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		probes.MarkElected()
+		return nil
+	}))
+	if err != nil {
+		klog.Fatalf("Unable to set up leader election probe: %s", err)
+	}
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		if !mgr.GetCache().WaitForCacheSync(stop) {
+			return fmt.Errorf("failed to wait for caches to sync")
+		}
+		if err := bootstrapRegistry(&c.Context); err != nil {
+			return err
+		}
+		probes.MarkBootstrapped()
+		return nil
+	}))
+	if err != nil {
+		klog.Fatalf("Unable to set up registry bootstrap: %s", err)
+	}
 
 	{
 		secretCtrl, err := controller.New("sync-secrets", mgr, controller.Options{
-			Reconciler: &SecretReconciler{Context: &c.Context},
+			Reconciler: probes.Track("sync-secrets", probes.AfterBootstrap(&SecretReconciler{Context: &c.Context})),
 		})
 		if err != nil {
 			klog.Fatalf("Unable to set up individual controller (sync-secrets): %s", err)
 		}
 
-		err = secretCtrl.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{})
+		err = secretCtrl.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, ignoreSyncStatusUpdates)
 		if err != nil {
 			klog.Fatalf("Unable to watch %T: %s", &corev1.Secret{}, err)
 		}
+		err = secretCtrl.Watch(&source.Kind{Type: &corev1.Secret{}}, enqueueConflictOwner(&c.Context))
+		if err != nil {
+			klog.Fatalf("Unable to watch conflicting %T: %s", &corev1.Secret{}, err)
+		}
+
+		if c.ResyncPeriod > 0 {
+			resyncEvents := make(chan event.GenericEvent)
+			err = secretCtrl.Watch(&source.Channel{Source: resyncEvents}, &handler.EnqueueRequestForObject{})
+			if err != nil {
+				klog.Fatalf("Unable to watch resync events: %s", err)
+			}
+
+			err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+				if !mgr.GetCache().WaitForCacheSync(stop) {
+					return fmt.Errorf("failed to wait for caches to sync")
+				}
+				runResync(&c.Context, c.ResyncPeriod, func(secret corev1.Secret) {
+					select {
+					case resyncEvents <- event.GenericEvent{Meta: &secret, Object: &secret}:
+					case <-stop:
+					}
+				}, stop)
+				return nil
+			}))
+			if err != nil {
+				klog.Fatalf("Unable to set up periodic resync: %s", err)
+			}
+		}
+
+		if c.RemoteClustersNamespace != "" {
+			remoteClusterEvents := make(chan event.GenericEvent)
+			err = secretCtrl.Watch(&source.Channel{Source: remoteClusterEvents}, &handler.EnqueueRequestForObject{})
+			if err != nil {
+				klog.Fatalf("Unable to watch remote cluster events: %s", err)
+			}
+
+			err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+				if !mgr.GetCache().WaitForCacheSync(stop) {
+					return fmt.Errorf("failed to wait for caches to sync")
+				}
+				runRemoteClusterUpdates(&c.Context, func(secret corev1.Secret) {
+					select {
+					case remoteClusterEvents <- event.GenericEvent{Meta: &secret, Object: &secret}:
+					case <-stop:
+					}
+				}, stop)
+				return nil
+			}))
+			if err != nil {
+				klog.Fatalf("Unable to set up remote cluster updates: %s", err)
+			}
+		}
+	}
+
+	if c.RemoteClustersNamespace != "" {
+		err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			if !mgr.GetCache().WaitForCacheSync(stop) {
+				return fmt.Errorf("failed to wait for caches to sync")
+			}
+			runRemoteClusterProbes(&c.Context, remoteClusterProbePeriod, stop)
+			return nil
+		}))
+		if err != nil {
+			klog.Fatalf("Unable to set up remote cluster probes: %s", err)
+		}
 	}
 
 	{
 		ownedSecretCtrl, err := controller.New("sync-owned-secrets", mgr, controller.Options{
-			Reconciler: &OwnedSecretReconcilier{Context: &c.Context},
+			Reconciler: probes.Track("sync-owned-secrets", probes.AfterBootstrap(&OwnedSecretReconcilier{Context: &c.Context})),
 		})
 		if err != nil {
 			klog.Fatalf("Unable to set up individual controller (sync-owned-secrets): %s", err)
 		}
 
-		err = ownedSecretCtrl.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{OwnerType: &corev1.Secret{}})
+		err = ownedSecretCtrl.Watch(&source.Kind{Type: &corev1.Secret{}}, enqueueOwnedSecret)
 		if err != nil {
 			klog.Fatalf("Unable to watch owned %T: %s", &corev1.Secret{}, err)
 		}
 	}
 
-	{
+	// NOTE: in namespace-scoped mode, the set of namespaces is fixed and
+	//       namespaces cannot be watched
+	if len(c.WatchNamespaces) == 0 {
 		namespaceCtrl, err := controller.New("sync-namespaces", mgr, controller.Options{
-			Reconciler: &NamespaceReconciler{Context: &c.Context},
+			Reconciler: probes.Track("sync-namespaces", probes.AfterBootstrap(&NamespaceReconciler{Context: &c.Context})),
 		})
 		if err != nil {
 			klog.Fatalf("Unable to set up individual controller (sync-namespaces): %s", err)
@@ -95,7 +291,17 @@ func (c *Controller) Run(stop <-chan struct{}) {
 	}
 
 	err = mgr.Start(stop)
+	c.Context.auditor.Close()
 	if err != nil {
 		klog.Fatalf("Failed to run overall controller manager: %s", err)
 	}
 }
+
+// newCacheFunc returns the function used to create the manager cache,
+// restricted to the given namespaces if any.
+func newCacheFunc(namespaces []string) cache.NewCacheFunc {
+	if len(namespaces) == 0 {
+		return cache.New
+	}
+	return cache.MultiNamespacedCacheBuilder(namespaces)
+}
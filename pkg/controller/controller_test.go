@@ -2,19 +2,34 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/cucumber/godog"
 	"github.com/cucumber/godog/colors"
 	"github.com/cucumber/messages-go/v10"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thoas/go-funk"
 	kubernetes_ctx "github.com/xunleii/godog-kubernetes"
 	"github.com/xunleii/godog-kubernetes/helpers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/audit"
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
 )
 
 var opts = godog.Options{Output: colors.Colored(os.Stdout)}
@@ -47,13 +62,66 @@ func TestMain(m *testing.M) {
 	os.Exit(status)
 }
 
+// eventRecorder records all events emitted during a scenario.
+type eventRecorder struct{ events []string }
+
+func (r *eventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	accessor, _ := meta.Accessor(object)
+	kind := reflect.TypeOf(object).Elem().Name()
+	name := types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}
+	r.events = append(r.events, fmt.Sprintf("%s %s on v1/%s '%s'", eventtype, reason, kind, strings.TrimPrefix(name.String(), "/")))
+}
+func (r *eventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+func (r *eventRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// auditSink records all audit records written during a scenario.
+type auditSink struct{ records []audit.Record }
+
+func (s *auditSink) Write(raw []byte) error {
+	record := audit.Record{}
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return err
+	}
+	if strings.Contains(string(raw), "bXktYXBw") {
+		return fmt.Errorf("audit record contains secret values: %s", raw)
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+// namespaceAuthorizer allows users to create secrets only in the
+// namespaces explicitly granted.
+type namespaceAuthorizer map[string][]string
+
+func (a namespaceAuthorizer) CanCreateSecrets(_ *Context, username string, _ []string, namespace string) (bool, error) {
+	return funk.ContainsString(a[username], namespace), nil
+}
+
 func InitializeScenario(s *godog.ScenarioContext) {
 	var ctx *Context
+	var recorder *eventRecorder
+	var authorizer namespaceAuthorizer
+	var auditor *auditSink
 	var reconcilers = map[string]reconcile.Reconciler{}
+	var drifts = map[string]float64{}
+	var result reconcile.Result
 
 	featureContext, _ := kubernetes_ctx.NewFeatureContext(s, kubernetes_ctx.WithFakeClient(scheme.Scheme))
 	s.BeforeScenario(func(*messages.Pickle) {
+		if ctx != nil {
+			ctx.auditor.Close()
+		}
 		ctx = NewContext(context.TODO(), featureContext.Client())
+		recorder = &eventRecorder{}
+		ctx.recorder = recorder
+		authorizer = namespaceAuthorizer{}
+		ctx.authorizer = authorizer
+		auditor = &auditSink{}
+		ctx.auditor = audit.New([]byte("audit-key"), nil, auditor)
 		reconcilers["secret"] = &SecretReconciler{ctx}
 		reconcilers["owned secret"] = &OwnedSecretReconcilier{ctx}
 		reconcilers["namespace"] = &NamespaceReconciler{ctx}
@@ -68,7 +136,7 @@ func InitializeScenario(s *godog.ScenarioContext) {
 				return err
 			}
 
-			_, err = reconcilers[reconciler].Reconcile(reconcile.Request{NamespacedName: target})
+			result, err = reconcilers[reconciler].Reconcile(reconcile.Request{NamespacedName: target})
 			switch err.(type) {
 			case NoAnnotationError:
 				return nil
@@ -77,6 +145,24 @@ func InitializeScenario(s *godog.ScenarioContext) {
 			}
 		},
 	)
+	s.Step(
+		`^the reconciliation is requeued$`,
+		func() error {
+			if result.RequeueAfter == 0 {
+				return fmt.Errorf("reconciliation not requeued")
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the reconciliation is not requeued$`,
+		func() error {
+			if result.RequeueAfter != 0 {
+				return fmt.Errorf("reconciliation requeued after %s", result.RequeueAfter)
+			}
+			return nil
+		},
+	)
 	s.Step(
 		`^the v1/Namespace '(.+)' is ignored by the reconciler$`,
 		func(namespace string) error {
@@ -84,6 +170,220 @@ func InitializeScenario(s *godog.ScenarioContext) {
 			return nil
 		},
 	)
+	s.Step(
+		`^the v1/Namespaces matching '(.+)' are ignored by the reconciler$`,
+		func(selector string) (err error) {
+			ctx.IgnoredNamespaceSelector, err = labels.Parse(selector)
+			return err
+		},
+	)
+	s.Step(
+		`^only the v1/Namespace '(.+)' can publish secrets$`,
+		func(namespace string) error {
+			ctx.SourceNamespaces = append(ctx.SourceNamespaces, namespace)
+			return nil
+		},
+	)
+	s.Step(
+		`^only the v1/Namespaces matching '(.+)' can publish secrets$`,
+		func(selector string) (err error) {
+			ctx.SourceNamespaceSelector, err = labels.Parse(selector)
+			return err
+		},
+	)
+	s.Step(
+		`^the reconciler only watches the v1/Namespaces '(.+)'$`,
+		func(namespaces string) error {
+			ctx.WatchNamespaces = strings.Split(namespaces, ",")
+			return nil
+		},
+	)
+	s.Step(
+		`^the synchronization is paused$`,
+		func() error {
+			ctx.Paused = true
+			return nil
+		},
+	)
+	s.Step(
+		`^the synchronization is resumed$`,
+		func() error {
+			ctx.Paused = false
+			return nil
+		},
+	)
+	s.Step(
+		`^the reconciler runs in dry-run mode$`,
+		func() error {
+			ctx.DryRun = true
+			return nil
+		},
+	)
+	s.Step(
+		`^the default conflict policy is '(.+)'$`,
+		func(policy string) error {
+			ctx.ConflictPolicy = policy
+			return nil
+		},
+	)
+	s.Step(
+		`^the secret type '(.+)' is denied by the reconciler$`,
+		func(_type string) error {
+			ctx.DeniedSecretTypes = append(ctx.DeniedSecretTypes, _type)
+			return nil
+		},
+	)
+	s.Step(
+		`^an? (Normal|Warning) '(\w+)' event is emitted on (v1/\w+ '`+kubernetes_ctx.RxNamespacedName+`')$`,
+		func(eventtype, reason, object string) error {
+			expected := fmt.Sprintf("%s %s on %s", eventtype, reason, object)
+			if !funk.ContainsString(recorder.events, expected) {
+				return fmt.Errorf("event '%s' not found in %v", expected, recorder.events)
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the annotator authorization is enabled$`,
+		func() error {
+			ctx.AuthorizeAnnotator = true
+			return nil
+		},
+	)
+	s.Step(
+		`^the user '(.+)' is allowed to create secrets in v1/Namespace '(.+)'$`,
+		func(username, namespace string) error {
+			authorizer[username] = append(authorizer[username], namespace)
+			return nil
+		},
+	)
+	s.Step(
+		`^an? '(create|update|delete)' audit record with reason '(\w+)' is written for v1/Secret '(`+kubernetes_ctx.RxNamespacedName+`)'(?: from v1/Secret '(`+kubernetes_ctx.RxNamespacedName+`)')?$`,
+		func(operation, reason, name, source string) error {
+			ctx.auditor.Flush()
+			for _, record := range auditor.records {
+				target := types.NamespacedName{Namespace: record.Target.Namespace, Name: record.Target.Name}
+				origin := types.NamespacedName{Namespace: record.Source.Namespace, Name: record.Source.Name}
+				if record.Operation == operation && record.Reason == reason && target.String() == name &&
+					(source == "" || origin.String() == source && record.Source.UID != "") {
+					return nil
+				}
+			}
+			return fmt.Errorf("audit record '%s %s %s' not found in %v", operation, reason, name, auditor.records)
+		},
+	)
+	s.Step(
+		`^the controller resyncs all secrets$`,
+		func() error {
+			var errs []error
+			for _, drift := range []string{missingDrift, staleDrift, orphanedDrift} {
+				drifts[drift] = testutil.ToFloat64(driftedOwnedSecretsTotal.WithLabelValues(drift))
+			}
+			err := resyncSecrets(ctx, func(secret corev1.Secret) {
+				name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+				if _, err := reconcilers["secret"].Reconcile(reconcile.Request{NamespacedName: name}); err != nil {
+					errs = append(errs, err)
+				}
+			})
+			for _, drift := range []string{missingDrift, staleDrift, orphanedDrift} {
+				drifts[drift] = testutil.ToFloat64(driftedOwnedSecretsTotal.WithLabelValues(drift)) - drifts[drift]
+			}
+			if err != nil {
+				return err
+			} else if len(errs) > 0 {
+				return fmt.Errorf("failed to reconcile drifted secrets: %v", errs)
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^(\d+) (missing|stale|orphaned) owned secrets? (?:is|are) detected$`,
+		func(count int, drift string) error {
+			if int(drifts[drift]) != count {
+				return fmt.Errorf("expected %d %s owned secrets, got %v", count, drift, drifts[drift])
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the registry is lost$`,
+		func() error {
+			ctx.registry = registry.New()
+			return nil
+		},
+	)
+	s.Step(
+		`^the controller restarts$`,
+		func() error {
+			ctx.registry = registry.New()
+			return bootstrapRegistry(ctx)
+		},
+	)
+	s.Step(
+		`^the registry has (\d+) owned secrets?$`,
+		func(count int) error {
+			if owned := ctx.registry.OwnedSecrets(); len(owned) != count {
+				return fmt.Errorf("expected %d owned secrets, got %d: %v", count, len(owned), owned)
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the registry has (\d+) versions? of v1/Secret '(`+kubernetes_ctx.RxNamespacedName+`)'$`,
+		func(count int, name string) error {
+			target, err := helpers.NamespacedNameFrom(name)
+			if err != nil {
+				return err
+			}
+
+			secret := corev1.Secret{}
+			if err := ctx.client.Get(ctx, target, &secret); err != nil {
+				return err
+			}
+			if versions := ctx.registry.VersionsWithUID(secret.UID); len(versions) != count {
+				return fmt.Errorf("expected %d versions, got %d", count, len(versions))
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^all owned secrets in v1/Namespace '(.+)' are removed$`,
+		func(namespace string) error {
+			secrets := corev1.SecretList{}
+			if err := ctx.client.List(ctx, &secrets, client.InNamespace(namespace), client.HasLabels{OriginNameLabelsKey}); err != nil {
+				return err
+			}
+			for i := range secrets.Items {
+				if err := ctx.client.Delete(ctx, &secrets.Items[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the owned secret reconciler reconciles all owned secrets in v1/Namespace '(.+)'$`,
+		func(namespace string) error {
+			for _, owned := range ctx.registry.OwnedSecrets() {
+				if owned.Namespace != namespace {
+					continue
+				}
+				if _, err := reconcilers["owned secret"].Reconcile(reconcile.Request{NamespacedName: owned}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	s.Step(
+		`^the registry has (\d+) conflicting secrets?$`,
+		func(count int) error {
+			if conflicts := ctx.registry.Conflicts(); len(conflicts) != count {
+				return fmt.Errorf("expected %d conflicting secrets, got %d: %v", count, len(conflicts), conflicts)
+			}
+			return nil
+		},
+	)
 	s.Step(
 		`^the (label|annotation) '(.+)' is protected by the reconciler$`,
 		func(_type string, field string) error {
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DeletionPolicyAnnotationKey is the annotation defining what happens to the
+// owned secrets when the managed secret is removed or no longer synchronized
+// on their namespace.
+const DeletionPolicyAnnotationKey = "secret.sync.klst.pw/deletion-policy"
+
+// OrphanFinalizer is the finalizer set on managed secrets with the orphan
+// deletion policy, in order to release their owned secrets before they are
+// garbage collected.
+const OrphanFinalizer = "secret.sync.klst.pw/orphan"
+
+// deletion policies
+const (
+	// DeleteDeletionPolicy removes the owned secrets.
+	DeleteDeletionPolicy = "delete"
+	// OrphanDeletionPolicy keeps the owned secrets, which are no longer
+	// managed by the controller.
+	OrphanDeletionPolicy = "orphan"
+)
+
+// DeletionPolicies lists all valid deletion policies.
+var DeletionPolicies = []string{DeleteDeletionPolicy, OrphanDeletionPolicy}
+
+// ValidateDeletionPolicy validates the given deletion policy.
+func ValidateDeletionPolicy(policy string) error {
+	if !funk.ContainsString(DeletionPolicies, policy) {
+		return fmt.Errorf("invalid deletion policy '%s': must be one of %v", policy, DeletionPolicies)
+	}
+	return nil
+}
+
+// isOrphanPolicy returns true if the owned secrets of the given managed
+// secret must be orphaned instead of being removed.
+func isOrphanPolicy(secret corev1.Secret) bool {
+	return secret.Annotations[DeletionPolicyAnnotationKey] == OrphanDeletionPolicy
+}
+
+// ensureOrphanFinalizer adds the orphan finalizer on the given managed secret
+// if its owned secrets must be orphaned, and removes it otherwise.
+func ensureOrphanFinalizer(ctx *Context, secret *corev1.Secret) error {
+	required := isOrphanPolicy(*secret) && hasSyncAnnotations(*secret)
+	if required == funk.ContainsString(secret.Finalizers, OrphanFinalizer) {
+		return nil
+	}
+
+	original := secret.DeepCopy()
+	if required {
+		secret.Finalizers = append(secret.Finalizers, OrphanFinalizer)
+	} else {
+		secret.Finalizers = funk.FilterString(secret.Finalizers, func(finalizer string) bool { return finalizer != OrphanFinalizer })
+	}
+
+	klog.V(3).Infof("update finalizers of %T %s/%s", secret, secret.Namespace, secret.Name)
+	if err := ctx.client.Patch(ctx, secret, client.MergeFrom(original)); err != nil {
+		return ClientError{fmt.Errorf("failed to update finalizers of %T %s/%s: %w", secret, secret.Namespace, secret.Name, err)}
+	}
+	return nil
+}
+
+// shouldOrphan returns true if the owned secret in the given namespace must
+// be orphaned instead of being removed. Owned secrets are always removed from
+// ignored namespaces.
+func shouldOrphan(ctx *Context, owner corev1.Secret, namespace string) bool {
+	if !isOrphanPolicy(owner) {
+		return false
+	}
+
+	ns := corev1.Namespace{}
+	if err := getNamespace(ctx, namespace, &ns); err != nil {
+		return false
+	}
+	return !isIgnoredNamespace(ctx, ns)
+}
+
+// finalizeSecret orphans all owned secrets of the given managed secret,
+// which is being deleted, and then removes its orphan finalizer.
+func finalizeSecret(ctx *Context, secret corev1.Secret) error {
+	if !funk.ContainsString(secret.Finalizers, OrphanFinalizer) {
+		return nil
+	}
+
+	if err := orphanOwnedSecrets(ctx, secret); err != nil {
+		return err
+	}
+	if err := orphanRemoteClusters(ctx, secret); err != nil {
+		return err
+	}
+	_ = ctx.registry.UnregisterSecret(secret.UID)
+
+	original := secret.DeepCopy()
+	secret.Finalizers = funk.FilterString(secret.Finalizers, func(finalizer string) bool { return finalizer != OrphanFinalizer })
+	if err := ctx.client.Patch(ctx, &secret, client.MergeFrom(original)); err != nil && !errors.IsNotFound(err) {
+		return ClientError{fmt.Errorf("failed to remove finalizer of %T %s/%s: %w", secret, secret.Namespace, secret.Name, err)}
+	}
+	return nil
+}
+
+// orphanOwnedSecrets orphans all owned secrets of the given managed secret.
+func orphanOwnedSecrets(ctx *Context, secret corev1.Secret) error {
+	// NOTE: owned secrets are also listed from the API server, because the
+	//       registry may not know all of them yet (before its bootstrap)
+	names := ctx.registry.OwnedSecretsWithUID(secret.UID)
+	owned, err := listOwnedSecrets(ctx, secret)
+	if err != nil {
+		return err
+	}
+	for _, owned := range owned {
+		name := types.NamespacedName{Namespace: owned.Namespace, Name: owned.Name}
+		if !funk.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if err := orphanOwnedSecret(ctx, secret, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orphanOwnedSecret releases the given owned secret: its origin labels and
+// owner reference are removed and it is no longer tracked by the registry,
+// but it is left in place.
+func orphanOwnedSecret(ctx *Context, owner corev1.Secret, name types.NamespacedName) error {
+	_ = ctx.registry.UnregisterOwnedSecret(name)
+
+	secret := &corev1.Secret{}
+	klog.V(3).Infof("fetch %T %s", secret, name)
+	if err := ctx.client.Get(ctx, name, secret); errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)}
+	}
+
+	// NOTE: the managed secret is captured before being removed from the
+	//       owned secret, in order to audit the orphaning
+	source := auditSource(secret)
+	delete(secret.Labels, OriginNameLabelsKey)
+	delete(secret.Labels, OriginNamespaceLabelsKey)
+	var references = secret.OwnerReferences[:0]
+	for _, reference := range secret.OwnerReferences {
+		if reference.UID != owner.UID {
+			references = append(references, reference)
+		}
+	}
+	secret.OwnerReferences = references
+
+	klog.V(3).Infof("orphan %T %s", secret, name)
+	if err := updateOwnedSecretOf(ctx, source, secret, OrphanedReason); err != nil {
+		ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to orphan owned secret %s: %s", name, err)
+		return ClientError{fmt.Errorf("failed to orphan %T %s: %w", secret, name, err)}
+	}
+	ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, OrphanedReason, "Owned secret %s orphaned", name)
+	ctx.recorder.Eventf(secret, corev1.EventTypeNormal, OrphanedReason, "Secret orphaned from %s/%s", owner.Namespace, owner.Name)
+	return nil
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// DryRunAnnotationKey is the annotation used to simulate the synchronization
+// of a secret; the operations on its owned secrets are only reported.
+const DryRunAnnotationKey = "secret.sync.klst.pw/dry-run"
+
+// isDryRun returns true if the synchronization of the given managed secret
+// must only be simulated, globally or through its annotation.
+func isDryRun(ctx *Context, secret corev1.Secret) bool {
+	if ctx.DryRun {
+		return true
+	}
+	dryRun, _ := strconv.ParseBool(secret.Annotations[DryRunAnnotationKey])
+	return dryRun
+}
+
+// newDryRunContext returns a copy of the given context used to simulate a
+// synchronization: operations on owned secrets are only reported, events
+// are flagged as dry-run and the registry changes are dropped with it.
+func newDryRunContext(ctx *Context) *Context {
+	simulation := *ctx
+	simulation.simulation = true
+	simulation.recorder = dryRunRecorder{ctx.recorder}
+	simulation.registry = ctx.registry.Copy()
+	return &simulation
+}
+
+// simulateOperation reports the given operation, which would be done on an
+// owned secret, with the changes it would make.
+func simulateOperation(ctx *Context, operation, reason string, secret *corev1.Secret) {
+	name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+
+	var live *corev1.Secret
+	if operation != createOperation {
+		live = &corev1.Secret{}
+		if err := ctx.client.Get(ctx, name, live); errors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			klog.Errorf("failed to fetch %T %s: %s", secret, name, err)
+			live = nil
+		}
+	}
+	desired := secret
+	if operation == deleteOperation {
+		desired = nil
+	}
+
+	changes := describeChanges(live, desired)
+	klog.V(0).Infof("[dry-run] would %s %T %s (%s): %s", operation, secret, name, reason, changes)
+	ownedSecretDryRunOperationsTotal.WithLabelValues(operation, secret.Namespace).Inc()
+	ctx.recorder.Eventf(secret, corev1.EventTypeNormal, DryRunReason, "Would %s secret (%s): %s", operation, reason, changes)
+}
+
+// describeChanges describes the changes between the live and the desired
+// secrets (nil if absent). Only the key names are described, never their
+// values.
+func describeChanges(live, desired *corev1.Secret) string {
+	if live == nil {
+		live = &corev1.Secret{}
+	}
+	if desired == nil {
+		desired = &corev1.Secret{}
+	}
+
+	data := func(secret *corev1.Secret) map[string]string {
+		values := map[string]string{}
+		for key, value := range secret.Data {
+			values[key] = string(value)
+		}
+		return values
+	}
+
+	var changes []string
+	if live.Type != desired.Type {
+		changes = append(changes, fmt.Sprintf("type: '%s' -> '%s'", live.Type, desired.Type))
+	}
+	if isImmutable(live) != isImmutable(desired) {
+		changes = append(changes, fmt.Sprintf("immutable: %t -> %t", isImmutable(live), isImmutable(desired)))
+	}
+	if diff := diffKeys(data(live), data(desired)); diff != "" {
+		changes = append(changes, "data: "+diff)
+	}
+	if diff := diffKeys(live.Labels, desired.Labels); diff != "" {
+		changes = append(changes, "labels: "+diff)
+	}
+	if diff := diffKeys(live.Annotations, desired.Annotations); diff != "" {
+		changes = append(changes, "annotations: "+diff)
+	}
+	if !reflect.DeepEqual(live.OwnerReferences, desired.OwnerReferences) && (len(live.OwnerReferences) > 0 || len(desired.OwnerReferences) > 0) {
+		changes = append(changes, "ownerReferences")
+	}
+
+	if len(changes) == 0 {
+		return "no change"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// diffKeys lists the keys added (+), updated (~) and removed (-) between
+// both maps.
+func diffKeys(live, desired map[string]string) string {
+	var keys []string
+	for key, value := range desired {
+		if previous, exists := live[key]; !exists {
+			keys = append(keys, "+"+key)
+		} else if previous != value {
+			keys = append(keys, "~"+key)
+		}
+	}
+	for key := range live {
+		if _, exists := desired[key]; !exists {
+			keys = append(keys, "-"+key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i][1:] < keys[j][1:] })
+	return strings.Join(keys, ", ")
+}
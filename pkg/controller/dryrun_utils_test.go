@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDescribeChanges(t *testing.T) {
+	live := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "my-app"},
+			Annotations: map[string]string{SourceHashAnnotationKey: "0123"},
+		},
+		Data: map[string][]byte{"username": []byte("my-app"), "password": []byte("39528$vdg7Jb")},
+	}
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "my-app"},
+			Annotations: map[string]string{SourceHashAnnotationKey: "4567"},
+		},
+		Data: map[string][]byte{"username": []byte("neoadmin"), "group": []byte("admin")},
+	}
+
+	tests := []struct {
+		name          string
+		live, desired *corev1.Secret
+		changes       string
+	}{
+		{"WithCreation", nil, desired, "data: +group, +username; labels: +app; annotations: +" + SourceHashAnnotationKey},
+		{"WithUpdate", live, desired, "data: +group, -password, ~username; annotations: ~" + SourceHashAnnotationKey},
+		{"WithDeletion", live, nil, "data: -password, -username; labels: -app; annotations: -" + SourceHashAnnotationKey},
+		{"WithoutChange", live, live.DeepCopy(), "no change"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := describeChanges(tt.live, tt.desired)
+			assert.Equal(t, tt.changes, changes)
+			assert.NotContains(t, changes, "neoadmin")
+		})
+	}
+}
@@ -5,4 +5,5 @@ type (
 	AnnotationError   struct{ error }
 	RegistryError     struct{ error }
 	ClientError       struct{ error }
+	PolicyError       struct{ error }
 )
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+// event reasons emitted by the controller
+const (
+	SyncedReason                = "Synced"
+	RestoredReason              = "Restored"
+	PrunedReason                = "Pruned"
+	AdoptedReason               = "Adopted"
+	OrphanedReason              = "Orphaned"
+	DryRunReason                = "DryRun"
+	RolloutTriggeredReason      = "RolloutTriggered"
+	RolloutBatchReason          = "RolloutBatch"
+	ExpiredReason               = "Expired"
+	RemoteClusterReadyReason    = "RemoteClusterReady"
+	AnnotationInvalidReason     = "AnnotationInvalid"
+	NameConflictReason          = "NameConflict"
+	TargetWriteFailedReason     = "TargetWriteFailed"
+	AnnotatorUnauthorizedReason = "AnnotatorUnauthorized"
+	SecretTypeDeniedReason      = "SecretTypeDenied"
+	SourceNamespaceDeniedReason = "SourceNamespaceDenied"
+	RolloutFailedReason         = "RolloutFailed"
+	RemoteSyncFailedReason      = "RemoteSyncFailed"
+	RemoteClusterFailedReason   = "RemoteClusterFailed"
+)
+
+// discardRecorder is an event recorder which drops all events. It is used
+// when no event recorder is provided to the context.
+type discardRecorder struct{}
+
+var _ record.EventRecorder = discardRecorder{}
+
+func (discardRecorder) Event(runtime.Object, string, string, string)                  {}
+func (discardRecorder) Eventf(runtime.Object, string, string, string, ...interface{}) {}
+func (discardRecorder) AnnotatedEventf(runtime.Object, map[string]string, string, string, string, ...interface{}) {
+}
+
+// dryRunRecorder is an event recorder which flags all events as dry-run. It
+// is used when the synchronization is only simulated.
+type dryRunRecorder struct{ record.EventRecorder }
+
+func (r dryRunRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, "[dry-run] "+message)
+}
+func (r dryRunRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, "[dry-run] "+messageFmt, args...)
+}
+func (r dryRunRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "[dry-run] "+messageFmt, args...)
+}
+
+// remoteClusterRecorder is an event recorder which flags all events with the
+// name of a remote cluster. It is used when a secret is synchronized on a
+// remote cluster; only the events on the local managed secrets are kept,
+// because the remote objects don't exist in the local cluster.
+type remoteClusterRecorder struct {
+	record.EventRecorder
+	cluster string
+	secrets *registry.Registry
+}
+
+func (r remoteClusterRecorder) isLocal(object runtime.Object) bool {
+	accessor, err := meta.Accessor(object)
+	return err == nil && r.secrets.SecretWithUID(accessor.GetUID()) != nil
+}
+func (r remoteClusterRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.isLocal(object) {
+		r.EventRecorder.Event(object, eventtype, reason, "["+r.cluster+"] "+message)
+	}
+}
+func (r remoteClusterRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.isLocal(object) {
+		r.EventRecorder.Eventf(object, eventtype, reason, "["+r.cluster+"] "+messageFmt, args...)
+	}
+}
+func (r remoteClusterRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.isLocal(object) {
+		r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "["+r.cluster+"] "+messageFmt, args...)
+	}
+}
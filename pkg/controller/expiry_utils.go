@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// expiration annotations, bounding the lifetime of the owned secrets;
+	// they can be set on the managed secret (all owned secrets) or on a
+	// namespace (owned secrets of this namespace only)
+	ExpiresAtAnnotationKey = "secret.sync.klst.pw/expires-at"
+	TTLAnnotationKey       = "secret.sync.klst.pw/ttl"
+)
+
+// parseExpiration returns the expiration time defined by the annotations of
+// the given object, or the zero time if it doesn't define any. A TTL is
+// relative to the given time.
+func parseExpiration(meta metav1.ObjectMeta, since time.Time) (time.Time, error) {
+	expiresAt, hasExpiresAt := meta.Annotations[ExpiresAtAnnotationKey]
+	ttl, hasTTL := meta.Annotations[TTLAnnotationKey]
+
+	switch {
+	case hasExpiresAt && hasTTL:
+		return time.Time{}, fmt.Errorf("annotation '%s' and '%s' cannot be used together", ExpiresAtAnnotationKey, TTLAnnotationKey)
+	case hasExpiresAt:
+		deadline, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("'%s' is not a RFC3339 time", ExpiresAtAnnotationKey)
+		}
+		return deadline, nil
+	case hasTTL:
+		duration, err := time.ParseDuration(ttl)
+		if err != nil || duration <= 0 {
+			return time.Time{}, fmt.Errorf("'%s' is not a positive duration", TTLAnnotationKey)
+		}
+		return since.Add(duration), nil
+	}
+	return time.Time{}, nil
+}
+
+// contentSyncedAt returns the time the current content of the given managed
+// secret has been synchronized for the first time; the TTL of the managed
+// secret is relative to it, so owned secrets updated in place expire once
+// their current content is older than the TTL. A content not synchronized
+// yet is synchronized now.
+func contentSyncedAt(ctx *Context, owner corev1.Secret) time.Time {
+	if !isPaused(ctx, owner) && owner.Annotations[ObservedHashAnnotationKey] != newOwnedSecretTemplate(ctx, &owner).Annotations[SourceHashAnnotationKey] {
+		return time.Now()
+	}
+
+	// NOTE: secrets synchronized before the observed date was recorded
+	//       have been synchronized, at the latest, at their last
+	//       synchronization date
+	for _, key := range []string{ObservedAtAnnotationKey, LastSyncedAtAnnotationKey} {
+		if syncedAt, err := time.Parse(time.RFC3339, owner.Annotations[key]); err == nil {
+			return syncedAt
+		}
+	}
+	return owner.CreationTimestamp.Time
+}
+
+// ownedSecretExpiration returns the expiration time of the owned secret of
+// the given managed secret, whose content has been synchronized at the given
+// time, in the given namespace (the earliest one between the managed secret
+// and the namespace expiration), or the zero time if it never expires. The
+// TTL of a namespace is relative to its creation. Invalid namespace
+// annotations are ignored.
+func ownedSecretExpiration(owner corev1.Secret, syncedAt time.Time, namespace corev1.Namespace) time.Time {
+	deadline, _ := parseExpiration(owner.ObjectMeta, syncedAt)
+
+	nsDeadline, err := parseExpiration(namespace.ObjectMeta, namespace.CreationTimestamp.Time)
+	if err != nil {
+		klog.Warningf("invalid expiration of %T %s: %s", namespace, namespace.Name, err)
+		return deadline
+	}
+	if deadline.IsZero() || (!nsDeadline.IsZero() && nsDeadline.Before(deadline)) {
+		return nsDeadline
+	}
+	return deadline
+}
+
+// isExpired returns true if the owned secret of the given managed secret,
+// whose content has been synchronized at the given time, in the given
+// namespace has expired.
+func isExpired(owner corev1.Secret, syncedAt time.Time, namespace corev1.Namespace) bool {
+	deadline := ownedSecretExpiration(owner, syncedAt, namespace)
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// isExpiredInNamespace returns true if the owned secret of the given
+// managed secret in the namespace with the given name has expired.
+func isExpiredInNamespace(ctx *Context, owner corev1.Secret, name string) (bool, error) {
+	namespace := corev1.Namespace{}
+	err := getNamespace(ctx, name, &namespace)
+	switch {
+	case errors.IsNotFound(err):
+		namespace.Name = name
+	case err != nil:
+		return false, ClientError{fmt.Errorf("failed to fetch %T %s: %w", namespace, name, err)}
+	}
+	return isExpired(owner, contentSyncedAt(ctx, owner), namespace), nil
+}
+
+// nextExpiration returns the delay before the next expiration of an owned
+// secret of the given managed secret in the given namespaces, or zero if
+// none of them expires.
+func nextExpiration(ctx *Context, owner corev1.Secret, namespaces []string) (time.Duration, error) {
+	var next time.Duration
+	syncedAt := contentSyncedAt(ctx, owner)
+	for _, name := range namespaces {
+		namespace := corev1.Namespace{}
+		err := getNamespace(ctx, name, &namespace)
+		switch {
+		case errors.IsNotFound(err):
+			continue
+		case err != nil:
+			return 0, ClientError{fmt.Errorf("failed to fetch %T %s: %w", namespace, name, err)}
+		}
+
+		deadline := ownedSecretExpiration(owner, syncedAt, namespace)
+		if deadline.IsZero() {
+			continue
+		}
+		if delay := time.Until(deadline); delay > 0 && (next == 0 || delay < next) {
+			next = delay
+		}
+	}
+	return next, nil
+}
+
+// minDelay returns the smallest non-zero delay, or zero if both are zero.
+func minDelay(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
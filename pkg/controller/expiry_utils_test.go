@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseExpiration(t *testing.T) {
+	since := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		deadline    time.Time
+		err         string
+	}{
+		{"WithoutAnnotation", nil, time.Time{}, ""},
+		{"WithExpiresAt", map[string]string{ExpiresAtAnnotationKey: "2020-06-02T00:00:00Z"}, time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC), ""},
+		{"WithTTL", map[string]string{TTLAnnotationKey: "8h"}, since.Add(8 * time.Hour), ""},
+		{"WithInvalidExpiresAt", map[string]string{ExpiresAtAnnotationKey: "tomorrow"}, time.Time{}, "'secret.sync.klst.pw/expires-at' is not a RFC3339 time"},
+		{"WithInvalidTTL", map[string]string{TTLAnnotationKey: "0s"}, time.Time{}, "'secret.sync.klst.pw/ttl' is not a positive duration"},
+		{
+			"WithBothAnnotations",
+			map[string]string{ExpiresAtAnnotationKey: "2020-06-02T00:00:00Z", TTLAnnotationKey: "8h"}, time.Time{},
+			"annotation 'secret.sync.klst.pw/expires-at' and 'secret.sync.klst.pw/ttl' cannot be used together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline, err := parseExpiration(metav1.ObjectMeta{Annotations: tt.annotations}, since)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.deadline.Equal(deadline), "expected %s, got %s", tt.deadline, deadline)
+		})
+	}
+}
+
+func TestOwnedSecretExpiration(t *testing.T) {
+	early, late := "2020-06-01T00:00:00Z", "2020-06-02T00:00:00Z"
+	earlyTime, _ := time.Parse(time.RFC3339, early)
+	lateTime, _ := time.Parse(time.RFC3339, late)
+
+	tests := []struct {
+		name             string
+		owner, namespace map[string]string
+		deadline         time.Time
+	}{
+		{"WithoutExpiration", nil, nil, time.Time{}},
+		{"WithSecretExpiration", map[string]string{ExpiresAtAnnotationKey: late}, nil, lateTime},
+		{"WithNamespaceExpiration", nil, map[string]string{ExpiresAtAnnotationKey: late}, lateTime},
+		{"WithEarlierNamespace", map[string]string{ExpiresAtAnnotationKey: late}, map[string]string{ExpiresAtAnnotationKey: early}, earlyTime},
+		{"WithEarlierSecret", map[string]string{ExpiresAtAnnotationKey: early}, map[string]string{ExpiresAtAnnotationKey: late}, earlyTime},
+		{"WithInvalidNamespace", map[string]string{ExpiresAtAnnotationKey: late}, map[string]string{TTLAnnotationKey: "never"}, lateTime},
+		{"WithSecretTTL", map[string]string{TTLAnnotationKey: "24h"}, nil, lateTime},
+		{"WithNamespaceTTL", nil, map[string]string{TTLAnnotationKey: "24h"}, earlyTime.Add(-24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// NOTE: the TTL of the managed secret is relative to the
+			//       synchronization of its content and the TTL of the
+			//       namespace to its creation
+			owner := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: tt.owner}}
+			namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:              "default",
+				Annotations:       tt.namespace,
+				CreationTimestamp: metav1.NewTime(earlyTime.Add(-48 * time.Hour)),
+			}}
+			deadline := ownedSecretExpiration(owner, earlyTime, namespace)
+			assert.True(t, tt.deadline.Equal(deadline), "expected %s, got %s", tt.deadline, deadline)
+		})
+	}
+}
+
+func TestContentSyncedAt(t *testing.T) {
+	created := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	synced, observed := "2020-06-02T00:00:00Z", "2020-06-03T00:00:00Z"
+	syncedTime, _ := time.Parse(time.RFC3339, synced)
+	observedTime, _ := time.Parse(time.RFC3339, observed)
+
+	ctx := &Context{}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret", CreationTimestamp: metav1.NewTime(created)},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	hash := newOwnedSecretTemplate(ctx, &secret).Annotations[SourceHashAnnotationKey]
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		syncedAt    time.Time
+	}{
+		{"WithoutStatus", map[string]string{ObservedHashAnnotationKey: hash}, created},
+		{"WithLastSyncedAt", map[string]string{ObservedHashAnnotationKey: hash, LastSyncedAtAnnotationKey: synced}, syncedTime},
+		{"WithObservedAt", map[string]string{ObservedHashAnnotationKey: hash, LastSyncedAtAnnotationKey: synced, ObservedAtAnnotationKey: observed}, observedTime},
+		{"WithChangedContent", map[string]string{ObservedHashAnnotationKey: "0000", ObservedAtAnnotationKey: observed}, time.Time{}},
+		{"WithPausedChangedContent", map[string]string{ObservedHashAnnotationKey: "0000", ObservedAtAnnotationKey: observed, PausedAnnotationKey: "true"}, observedTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := *secret.DeepCopy()
+			secret.Annotations = tt.annotations
+			syncedAt := contentSyncedAt(ctx, secret)
+			if tt.syncedAt.IsZero() {
+				// NOTE: a content not synchronized yet is synchronized now
+				assert.WithinDuration(t, time.Now(), syncedAt, time.Minute)
+				return
+			}
+			assert.True(t, tt.syncedAt.Equal(syncedAt), "expected %s, got %s", tt.syncedAt, syncedAt)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+@expiration
+Feature: Time-bounded owned secrets
+  Owned secrets should be removed once they have expired,
+  based on the secret or on the namespace annotations.
+
+  Background:
+    Given Kubernetes must have the following resources
+      | ApiGroupVersion | Kind      | Namespace | Name        |
+      | v1              | Namespace |           | kube-system |
+      | v1              | Namespace |           | kube-public |
+      | v1              | Namespace |           | default     |
+    And Kubernetes labelizes v1/Namespace 'kube-public' with 'sync=secret'
+    And Kubernetes labelizes v1/Namespace 'kube-system' with 'sync=secret'
+
+  @create
+  Scenario: Secret not expired yet
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/expires-at: '2100-01-01T00:00:00Z'
+      data:
+        username: bXktYXBw
+      """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes has v1/Secret 'kube-system/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have annotation 'secret.sync.klst.pw/expires-at'
+    And the reconciliation is requeued
+
+  @delete
+  Scenario: Secret expired
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    When Kubernetes annotates v1/Secret 'default/secret' with 'secret.sync.klst.pw/expires-at=2000-01-01T00:00:00Z'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    And a Normal 'Expired' event is emitted on v1/Secret 'default/secret'
+    And the reconciliation is not requeued
+    When the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
+  @update
+  Scenario: Secret expired with a TTL is synchronized again when its content changes
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/ttl: 1h
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/observed-at'
+    When Kubernetes annotates v1/Secret 'default/secret' with 'secret.sync.klst.pw/observed-at=2000-01-01T00:00:00Z'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And a Normal 'Expired' event is emitted on v1/Secret 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmV3LWFwcA==
+      """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes has v1/Secret 'kube-system/secret'
+    And the reconciliation is requeued
+
+  @delete
+  Scenario: Secret expired in a namespace
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/deletion-policy: orphan
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes annotates v1/Namespace 'kube-system' with 'secret.sync.klst.pw/expires-at=2000-01-01T00:00:00Z'
+    And the namespace reconciler reconciles 'kube-system'
+    Then Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    But Kubernetes has v1/Secret 'kube-public/secret'
+    And a Normal 'Expired' event is emitted on v1/Secret 'default/secret'
+
+  @invalid
+  Scenario Outline: Invalid expiration (<annotation>: <value>)
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          <annotation>: '<value>'
+      """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And a Warning 'AnnotationInvalid' event is emitted on v1/Secret 'default/secret'
+
+    Examples:
+      | annotation                     | value    |
+      | secret.sync.klst.pw/expires-at | tomorrow |
+      | secret.sync.klst.pw/ttl        | -1h      |
+
+  @create @conflict
+  Scenario: Secret not expired yet with a conflicting secret
+    Given Kubernetes creates a new v1/Secret 'kube-public/secret'
+    And the default conflict policy is 'fail'
+    And Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/expires-at: '2100-01-01T00:00:00Z'
+      data:
+        username: bXktYXBw
+      """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-system/secret'
+    But Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And a Warning 'NameConflict' event is emitted on v1/Secret 'default/secret'
+    And the reconciliation is requeued
@@ -77,6 +77,59 @@ Feature: Secret synchronization on Namespace's updates
     And the namespace reconciler reconciles 'kubetest'
     Then Kubernetes doesn't have v1/Secret 'kubetest/secret'
 
+  @create
+  Scenario: Namespace created with 'secret.sync.klst.pw/ignore' label
+    When Kubernetes creates a new v1/Namespace 'kubetest' with
+    """
+    metadata:
+      labels:
+        sync: 'secret'
+        secret.sync.klst.pw/ignore: 'true'
+    """
+    And the namespace reconciler reconciles 'kubetest'
+    Then Kubernetes doesn't have v1/Secret 'kubetest/secret'
+
+  @create
+  Scenario: Namespace created with a label matching the ignored namespace selector
+    Given the v1/Namespaces matching 'environment=production' are ignored by the reconciler
+    When Kubernetes creates a new v1/Namespace 'kubetest' with
+    """
+    metadata:
+      labels:
+        sync: 'secret'
+        environment: 'production'
+    """
+    And the namespace reconciler reconciles 'kubetest'
+    Then Kubernetes doesn't have v1/Secret 'kubetest/secret'
+
+  @create
+  Scenario: Namespace created with a name matching an ignored pattern
+    Given the v1/Namespace 'kube*' is ignored by the reconciler
+    When Kubernetes creates a new v1/Namespace 'kubetest' with
+    """
+    metadata:
+      labels:
+        sync: 'secret'
+    """
+    And the namespace reconciler reconciles 'kubetest'
+    Then Kubernetes doesn't have v1/Secret 'kubetest/secret'
+
+  @update
+  Scenario: Namespace is updated with the 'secret.sync.klst.pw/ignore' label
+    When Kubernetes creates a new v1/Namespace 'kubetest' with
+    """
+    metadata:
+      labels:
+        sync: 'secret'
+    """
+    And the namespace reconciler reconciles 'kubetest'
+    And Kubernetes has v1/Secret 'kubetest/secret'
+    And Kubernetes labelizes v1/Namespace 'kubetest' with 'secret.sync.klst.pw/ignore=true'
+    And the namespace reconciler reconciles 'kubetest'
+    Then Kubernetes doesn't have v1/Secret 'kubetest/secret'
+    And a Normal 'Pruned' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes has v1/Secret 'kube-public/secret'
+
   @delete
   Scenario: Namespace is removed
     When Kubernetes creates a new v1/Namespace 'kubetest' with
@@ -30,13 +30,38 @@ Feature: Secret synchronization on Owned secret's updates
   @no_update
   Scenario: Owned secret already up-to-date
     When the owned secret reconciler reconciles 'kube-public/secret'
-    Then nothing occurs
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
 
   @update
   Scenario: Owned secret is updated
     When Kubernetes annotates v1/Secret 'kube-public/secret' with 'modified=true'
     And the owned secret reconciler reconciles 'kube-public/secret'
     Then Kubernetes resource v1/Secret 'kube-public/secret' doesn't have annotation 'modified'
+    And a Normal 'Restored' event is emitted on v1/Secret 'default/secret'
+    And a Normal 'Restored' event is emitted on v1/Secret 'kube-public/secret'
+    And an 'update' audit record with reason 'Restored' is written for v1/Secret 'kube-public/secret'
+
+  @update @paused
+  Scenario: Owned secret of a paused secret is updated
+    Given Kubernetes annotates v1/Secret 'default/secret' with 'secret.sync.klst.pw/paused=true'
+    When Kubernetes annotates v1/Secret 'kube-public/secret' with 'modified=true'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has annotation 'modified'
+
+  @delete @paused
+  Scenario: Owned secret is removed while the synchronization is paused
+    Given the synchronization is paused
+    When Kubernetes removes v1/Secret 'kube-public/secret'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
+  @update @dry_run
+  Scenario: Owned secret is updated in dry-run mode
+    Given the reconciler runs in dry-run mode
+    When Kubernetes annotates v1/Secret 'kube-public/secret' with 'modified=true'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has annotation 'modified'
+    And a Normal 'DryRun' event is emitted on v1/Secret 'kube-public/secret'
 
   @delete
   Scenario: Owned secret is removed
@@ -45,3 +70,51 @@ Feature: Secret synchronization on Owned secret's updates
     And the owned secret reconciler reconciles 'kube-public/secret'
     Then Kubernetes has v1/Secret 'kube-public/secret'
     And Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+
+  @delete
+  Scenario: Owned secret is removed after a restart of the controller
+    Given the controller restarts
+    When Kubernetes removes v1/Secret 'kube-public/secret'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+
+  @delete @policy
+  Scenario: Owned secret of a denied secret is removed after a restart of the controller
+    Given only the v1/Namespace 'platform-*' can publish secrets
+    And the controller restarts
+    When Kubernetes removes v1/Secret 'kube-public/secret'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    When the secret reconciler reconciles 'default/secret'
+    Then a Warning 'SourceNamespaceDenied' event is emitted on v1/Secret 'default/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @update @policy
+  Scenario: Owned secret of a secret becoming denied is updated
+    Given only the v1/Namespace 'platform-*' can publish secrets
+    When Kubernetes annotates v1/Secret 'kube-public/secret' with 'modified=true'
+    And the owned secret reconciler reconciles 'kube-public/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has annotation 'modified'
+
+  @update @policy
+  Scenario: Owned secret of a denied secret type is updated after a restart of the controller
+    Given Kubernetes creates a new v1/Secret 'default/token' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/all-namespaces: 'true'
+      type: kubernetes.io/service-account-token
+      data:
+        token: bXktdG9rZW4=
+      """
+    And the secret reconciler reconciles 'default/token'
+    And the secret type 'kubernetes.io/service-account-token' is denied by the reconciler
+    And the controller restarts
+    When Kubernetes annotates v1/Secret 'kube-public/token' with 'modified=true'
+    And the owned secret reconciler reconciles 'kube-public/token'
+    Then Kubernetes resource v1/Secret 'kube-public/token' has annotation 'modified'
+    When the secret reconciler reconciles 'default/token'
+    Then a Warning 'SecretTypeDenied' event is emitted on v1/Secret 'default/token'
+    And Kubernetes doesn't have v1/Secret 'kube-public/token'
+    And Kubernetes doesn't have v1/Secret 'kube-system/token'
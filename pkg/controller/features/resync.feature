@@ -0,0 +1,91 @@
+@resync
+Feature: Periodic resync of all secrets
+  Owned secrets which have drifted from their original
+  secret should be fixed by the periodic resync.
+
+  Background:
+    Given Kubernetes must have the following resources
+      | ApiGroupVersion | Kind      | Namespace | Name        |
+      | v1              | Namespace |           | kube-system |
+      | v1              | Namespace |           | kube-public |
+      | v1              | Namespace |           | default     |
+    And Kubernetes labelizes v1/Namespace 'kube-public' with 'sync=secret'
+    And Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: 'sync=secret'
+      data:
+        username: bXktYXBw
+        password: Mzk1MjgkdmRnN0pi
+      """
+    And the secret reconciler reconciles 'default/secret'
+
+  @no_update
+  Scenario: Nothing has drifted
+    When the controller resyncs all secrets
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
+    And 0 missing owned secret is detected
+    And 0 stale owned secret is detected
+    And 0 orphaned owned secret is detected
+
+  @create
+  Scenario: Owned secret is missing
+    Given Kubernetes labelizes v1/Namespace 'kube-system' with 'sync=secret'
+    When the controller resyncs all secrets
+    Then Kubernetes has v1/Secret 'kube-system/secret'
+    And Kubernetes resource v1/Secret 'kube-system/secret' is similar to 'default/secret'
+    And 1 missing owned secret is detected
+
+  @update
+  Scenario: Owned secret is stale
+    Given Kubernetes patches v1/Secret 'kube-public/secret' with
+      """
+      data:
+        password: cGFzc3dvcmQ=
+      """
+    When the controller resyncs all secrets
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    And 1 stale owned secret is detected
+
+  @delete
+  Scenario: Owned secret is no longer selected and the registry has drifted
+    Given the registry is lost
+    And Kubernetes removes label 'sync' on v1/Namespace 'kube-public'
+    When the controller resyncs all secrets
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And 1 orphaned owned secret is detected
+
+  @no_update @dry_run
+  Scenario: Owned secret of a secret in dry-run is no longer selected and the registry has drifted
+    Given Kubernetes patches v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/dry-run: 'true'
+      """
+    And the registry is lost
+    And Kubernetes removes label 'sync' on v1/Namespace 'kube-public'
+    When the controller resyncs all secrets
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And the registry has 0 owned secret
+    And 1 orphaned owned secret is detected
+
+  @delete
+  Scenario: Owned secret of a removed secret
+    Given Kubernetes creates a new v1/Secret 'kube-system/removed' with
+      """
+      metadata:
+        labels:
+          secret.sync.klst.pw/origin.name: removed
+          secret.sync.klst.pw/origin.namespace: default
+        ownerReferences:
+        - apiVersion: v1
+          kind: Secret
+          name: removed
+          uid: 00000000-0000-0000-0000-000000000000
+      """
+    When the controller resyncs all secrets
+    Then Kubernetes doesn't have v1/Secret 'kube-system/removed'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    And 1 orphaned owned secret is detected
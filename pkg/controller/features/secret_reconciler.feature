@@ -37,6 +37,9 @@ Feature: Secret synchronization on secret's updates
     """
     When the secret reconciler reconciles 'default/secret'
     Then Kubernetes has v1/Secret 'default/secret'
+    And a Warning 'AnnotationInvalid' event is emitted on v1/Secret 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/sync-errors'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/last-synced-at'
     But Kubernetes doesn't have v1/Secret 'kube-public/secret'
     And Kubernetes doesn't have v1/Secret 'kube-system/secret'
 
@@ -56,6 +59,14 @@ Feature: Secret synchronization on secret's updates
     And Kubernetes resource v1/Secret 'kube-public/secret' has label 'secret.sync.klst.pw/origin.name=secret'
     And Kubernetes resource v1/Secret 'kube-public/secret' has label 'secret.sync.klst.pw/origin.namespace=default'
     And Kubernetes resource v1/Secret 'kube-system/secret' is equal to 'kube-public/secret'
+    And a Normal 'Synced' event is emitted on v1/Secret 'default/secret'
+    And a Normal 'Synced' event is emitted on v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/synced-namespaces=kube-public,kube-system'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/last-synced-at'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/observed-hash'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/sync-errors'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have annotation 'secret.sync.klst.pw/synced-namespaces'
+    And a 'create' audit record with reason 'Synced' is written for v1/Secret 'kube-public/secret'
 
   @create
   Scenario: Secret is created with 'secret.sync.klst.pw/namespace-selector'
@@ -101,6 +112,194 @@ Feature: Secret synchronization on secret's updates
     Then Kubernetes has v1/Secret 'kube-system/secret'
     But Kubernetes doesn't have v1/Secret 'kube-public/secret'
 
+  @create @policy
+  Scenario: Secret is created with a denied type
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    type: kubernetes.io/service-account-token
+    """
+    And the secret type 'kubernetes.io/service-account-token' is denied by the reconciler
+    When the secret reconciler reconciles 'default/secret'
+    Then a Warning 'SecretTypeDenied' event is emitted on v1/Secret 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/sync-errors'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @update @policy
+  Scenario: Secret's type becomes denied
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    type: helm.sh/release.v1
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    And the secret type 'helm.sh/release.v1' is denied by the reconciler
+    When the secret reconciler reconciles 'default/secret'
+    Then a Warning 'SecretTypeDenied' event is emitted on v1/Secret 'default/secret'
+    And a Normal 'Pruned' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create @policy
+  Scenario: Secret is created on a namespace not allowed to publish secrets
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And only the v1/Namespace 'platform-*' can publish secrets
+    When the secret reconciler reconciles 'default/secret'
+    Then a Warning 'SourceNamespaceDenied' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create @policy
+  Scenario: Secret is created on a namespace allowed to publish secrets
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    """
+    And Kubernetes labelizes v1/Namespace 'default' with 'secrets=publisher'
+    And only the v1/Namespaces matching 'secrets=publisher' can publish secrets
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+
+  @create @authorization
+  Scenario: Secret is only synced where the annotator is authorized
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+        secret.sync.klst.pw/annotated-by: alice
+    """
+    And the annotator authorization is enabled
+    And the user 'alice' is allowed to create secrets in v1/Namespace 'kube-public'
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have annotation 'secret.sync.klst.pw/annotated-by'
+    And a Warning 'AnnotatorUnauthorized' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create @authorization
+  Scenario: Secret without annotator is not synced
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And the annotator authorization is enabled
+    When the secret reconciler reconciles 'default/secret'
+    Then a Warning 'AnnotatorUnauthorized' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @update @authorization
+  Scenario: Secret without annotator keeps its owned secrets when the annotator authorization is enabled
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    And the annotator authorization is enabled
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: null
+        secret.sync.klst.pw/all-namespaces: 'true'
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then a Warning 'AnnotatorUnauthorized' event is emitted on v1/Secret 'default/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'data.username=bmVvYWRtaW4K'
+    But Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create
+  Scenario: Namespaces where a secret will be synced are ignored by pattern
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And the v1/Namespace 'kube-*' is ignored by the reconciler
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create
+  Scenario: Namespaces where a secret will be synced are ignored by selector
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And the v1/Namespaces matching 'sync=secret' are ignored by the reconciler
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-system/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
+  @create
+  Scenario: Secret is created on a namespace ignored by selector
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And Kubernetes labelizes v1/Namespace 'default' with 'team=platform'
+    And the v1/Namespaces matching 'team=platform' are ignored by the reconciler
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/synced-namespaces'
+
+  @create @scoped
+  Scenario: Secret is created in namespace-scoped mode
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And the reconciler only watches the v1/Namespaces 'default,kube-public,not-exists'
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/synced-namespaces=kube-public'
+    But Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create @scoped
+  Scenario: Secret is created with selector in namespace-scoped mode
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    """
+    And Kubernetes labelizes v1/Namespace 'kube-system' with 'sync=secret'
+    And the reconciler only watches the v1/Namespaces 'default,kube-system'
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-system/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
   @create
   Scenario: Synced secret already exists
     Given Kubernetes must have v1/Secret 'default/secret' with
@@ -117,6 +316,78 @@ Feature: Secret synchronization on secret's updates
     Then Kubernetes resource v1/Secret 'kube-public/secret' is not similar to 'default/secret'
     And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
     And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.namespace'
+    And the registry has 1 conflicting secret
+    And a Warning 'NameConflict' event is emitted on v1/Secret 'default/secret'
+    And a Warning 'NameConflict' event is emitted on v1/Secret 'kube-public/secret'
+
+  @create
+  Scenario Outline: Synced secret already exists (conflict policy '<policy>')
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/conflict-policy: <policy>
+    data:
+      username: bXktYXBw
+      password: Mzk1MjgkdmRnN0pi
+    """
+    And Kubernetes must have v1/Secret 'kube-public/secret' with
+    """
+    data:
+      username: bXktYXBw
+      password: <password>
+    """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' <similarity> to 'default/secret'
+    And the registry has <conflicts> conflicting secret
+    And a <eventtype> '<reason>' event is emitted on v1/Secret 'default/secret'
+    And a <eventtype> '<reason>' event is emitted on v1/Secret 'kube-public/secret'
+
+    Examples:
+      | policy             | password         | similarity     | conflicts | eventtype | reason       |
+      | skip               | cGFzc3dvcmQ=     | is not similar | 1         | Warning   | NameConflict |
+      | adopt              | cGFzc3dvcmQ=     | is similar     | 0         | Normal    | Adopted      |
+      | adopt-if-identical | Mzk1MjgkdmRnN0pi | is similar     | 0         | Normal    | Adopted      |
+      | adopt-if-identical | cGFzc3dvcmQ=     | is not similar | 1         | Warning   | NameConflict |
+      | fail               | cGFzc3dvcmQ=     | is not similar | 1         | Warning   | NameConflict |
+
+  @create
+  Scenario: Synced secret already exists and fails the synchronization
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And Kubernetes creates a new v1/Secret 'kube-public/secret'
+    And the default conflict policy is 'fail'
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-system/secret'
+    But Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/sync-errors'
+
+  @create
+  Scenario: Synced secret owned by someone else cannot be adopted
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/conflict-policy: adopt
+    """
+    And Kubernetes must have v1/Secret 'kube-public/secret' with
+    """
+    metadata:
+      ownerReferences:
+      - apiVersion: v1
+        kind: Secret
+        name: other
+        uid: 00000000-0000-0000-0000-000000000000
+    """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And a Warning 'NameConflict' event is emitted on v1/Secret 'default/secret'
 
   @create
   Scenario: Secret has protected label
@@ -145,6 +416,23 @@ Feature: Secret synchronization on secret's updates
     When the secret reconciler reconciles 'default/secret'
     Then Kubernetes resource v1/Secret 'kube-public/secret' doesn't have annotation 'do-not-copy'
 
+  @no_update
+  Scenario: Secret is reconciled without any change
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    data:
+      username: bXktYXBw
+      password: Mzk1MjgkdmRnN0pi
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' has annotation 'secret.sync.klst.pw/source-hash'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
+    And Kubernetes resource v1/Secret 'kube-system/secret' has 'metadata.resourceVersion=1'
+
   @update
   Scenario: Secret's content is updated
     Given Kubernetes must have v1/Secret 'default/secret' with
@@ -168,6 +456,206 @@ Feature: Secret synchronization on secret's updates
     Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
     And Kubernetes resource v1/Secret 'kube-system/secret' is equal to 'kube-public/secret'
 
+  @update @paused
+  Scenario: Paused secret's content is updated
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes annotates v1/Secret 'default/secret' with 'secret.sync.klst.pw/paused=true'
+    And Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/synced-namespaces=kube-public,kube-system'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/sync-errors'
+    When Kubernetes removes annotation 'secret.sync.klst.pw/paused' on v1/Secret 'default/secret'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'kube-system/secret' is equal to 'kube-public/secret'
+
+  @update @paused
+  Scenario: Secret's annotation is removed while the synchronization is paused
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And the synchronization is paused
+    When Kubernetes removes annotation 'secret.sync.klst.pw/all-namespaces' on v1/Secret 'default/secret'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes has v1/Secret 'kube-system/secret'
+    When the synchronization is resumed
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @create @dry_run
+  Scenario: Secret is created in dry-run mode
+    Given the reconciler runs in dry-run mode
+    And Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+    """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/synced-namespaces'
+    And the registry has 0 owned secret
+    And a Normal 'DryRun' event is emitted on v1/Secret 'kube-public/secret'
+    And a Normal 'DryRun' event is emitted on v1/Secret 'kube-system/secret'
+
+  @update @dry_run
+  Scenario: Secret's content is updated in dry-run
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes annotates v1/Secret 'default/secret' with 'secret.sync.klst.pw/dry-run=true'
+    And Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And Kubernetes updates annotation 'secret.sync.klst.pw/namespace-selector' on v1/Secret 'default/secret' with 'sync!=secret'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
+    But Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    And a Normal 'DryRun' event is emitted on v1/Secret 'kube-public/secret'
+    And a Normal 'DryRun' event is emitted on v1/Secret 'kube-system/secret'
+    And the registry has 1 owned secret
+    When Kubernetes removes annotation 'secret.sync.klst.pw/dry-run' on v1/Secret 'default/secret'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-system/secret' is similar to 'default/secret'
+
+  @update @immutable
+  Scenario: Immutable secret's content is updated
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    immutable: true
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'immutable=true'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'immutable=true'
+    And Kubernetes resource v1/Secret 'kube-public/secret' has 'metadata.resourceVersion=1'
+
+  @update @versioned
+  Scenario: Secret with versioned name is updated
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/versioned-name: 'true'
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has 1 v1/Secret in namespace 'kube-public'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has 1 v1/Secret in namespace 'kube-public'
+    And a Normal 'Pruned' event is emitted on v1/Secret 'default/secret'
+    But Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
+  @update @versioned
+  Scenario: Secret with versioned name keeps its history
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/versioned-name: 'true'
+        secret.sync.klst.pw/version-history: '2'
+        secret.sync.klst.pw/stable-name: 'true'
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has 2 v1/Secret in namespace 'kube-public'
+    And Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has 3 v1/Secret in namespace 'kube-public'
+    And Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    And the registry has 2 versions of v1/Secret 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: cm9vdAo=
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has 3 v1/Secret in namespace 'kube-public'
+    And a Normal 'Pruned' event is emitted on v1/Secret 'default/secret'
+    And the registry has 2 versions of v1/Secret 'default/secret'
+
+  @update @versioned
+  Scenario: Secret versions are restored
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/versioned-name: 'true'
+        secret.sync.klst.pw/version-history: '3'
+    data:
+      username: bXktYXBw
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has 2 v1/Secret in namespace 'kube-public'
+    When the controller restarts
+    And all owned secrets in v1/Namespace 'kube-public' are removed
+    And the owned secret reconciler reconciles all owned secrets in v1/Namespace 'kube-public'
+    Then Kubernetes has 2 v1/Secret in namespace 'kube-public'
+    And the registry has 2 versions of v1/Secret 'default/secret'
+
   @update
   Scenario: Secret's annotation is updated
     Given Kubernetes must have v1/Secret 'default/secret' with
@@ -196,6 +684,10 @@ Feature: Secret synchronization on secret's updates
     And the secret reconciler reconciles 'default/secret'
     Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
     And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+    And a Normal 'Pruned' event is emitted on v1/Secret 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/synced-namespaces'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have annotation 'secret.sync.klst.pw/observed-hash'
+    And a 'delete' audit record with reason 'Pruned' is written for v1/Secret 'kube-system/secret' from v1/Secret 'default/secret'
 
   @delete
   Scenario: Secret is removed
@@ -211,3 +703,205 @@ Feature: Secret synchronization on secret's updates
     Then Kubernetes doesn't have v1/Secret 'default/secret'
     And Kubernetes doesn't have v1/Secret 'kube-public/secret'
     And Kubernetes doesn't have v1/Secret 'kube-system/secret'
+
+  @delete
+  Scenario: Secret with 'orphan' deletion policy is removed
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+        secret.sync.klst.pw/deletion-policy: orphan
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has 'metadata.finalizers'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    metadata:
+      deletionTimestamp: '2020-01-01T00:00:00Z'
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'default/secret' doesn't have 'metadata.finalizers'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have 'metadata.ownerReferences'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And Kubernetes resource v1/Secret 'kube-system/secret' doesn't have label 'secret.sync.klst.pw/origin.namespace'
+    And a Normal 'Orphaned' event is emitted on v1/Secret 'kube-public/secret'
+    And a 'update' audit record with reason 'Orphaned' is written for v1/Secret 'kube-system/secret' from v1/Secret 'default/secret'
+
+  @delete
+  Scenario: Secret with 'orphan' deletion policy is removed before the registry bootstrap
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+        secret.sync.klst.pw/deletion-policy: orphan
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And the registry is lost
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    metadata:
+      deletionTimestamp: '2020-01-01T00:00:00Z'
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'default/secret' doesn't have 'metadata.finalizers'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have 'metadata.ownerReferences'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And a Normal 'Orphaned' event is emitted on v1/Secret 'kube-public/secret'
+
+  @update
+  Scenario: Secret's annotation with 'orphan' deletion policy is removed
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/all-namespaces: 'true'
+        secret.sync.klst.pw/deletion-policy: orphan
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes removes annotation 'secret.sync.klst.pw/all-namespaces' on v1/Secret 'default/secret'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have 'metadata.ownerReferences'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And Kubernetes resource v1/Secret 'default/secret' doesn't have 'metadata.finalizers'
+    And a Normal 'Orphaned' event is emitted on v1/Secret 'default/secret'
+
+  @update
+  Scenario: Secret with 'orphan' deletion policy stops selecting a namespace
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/deletion-policy: orphan
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes removes label 'sync' on v1/Namespace 'kube-public'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'kube-public/secret'
+    And Kubernetes resource v1/Secret 'kube-public/secret' doesn't have label 'secret.sync.klst.pw/origin.name'
+    And Kubernetes resource v1/Secret 'default/secret' has 'metadata.finalizers'
+    And the registry has 0 owned secret
+
+  @update
+  Scenario: Secret with 'orphan' deletion policy is removed from an ignored namespace
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/deletion-policy: orphan
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes labelizes v1/Namespace 'kube-public' with 'secret.sync.klst.pw/ignore=true'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes doesn't have v1/Secret 'kube-public/secret'
+
+  @update @rollout
+  Scenario: Secret with 'rollout-on-change' is updated
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+        secret.sync.klst.pw/rollout-on-change: 'true'
+    data:
+      username: bXktYXBw
+    """
+    And Kubernetes must have apps/v1/Deployment 'kube-public/env-from' with
+    """
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            envFrom:
+            - secretRef:
+                name: secret
+    """
+    And Kubernetes must have apps/v1/StatefulSet 'kube-public/volume' with
+    """
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+          volumes:
+          - name: secret
+            secret:
+              secretName: secret
+    """
+    And Kubernetes must have apps/v1/DaemonSet 'kube-public/env' with
+    """
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            env:
+            - name: USERNAME
+              valueFrom:
+                secretKeyRef:
+                  name: secret
+                  key: username
+    """
+    And Kubernetes must have apps/v1/Deployment 'kube-public/unrelated' with
+    """
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            envFrom:
+            - secretRef:
+                name: other
+    """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes resource apps/v1/Deployment 'kube-public/env-from' doesn't have 'spec.template.metadata.annotations'
+    When Kubernetes annotates v1/Secret 'default/secret' with 'unrelated=true'
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource apps/v1/Deployment 'kube-public/env-from' doesn't have 'spec.template.metadata.annotations'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource apps/v1/Deployment 'kube-public/env-from' has 'spec.template.metadata.annotations'
+    And Kubernetes resource apps/v1/StatefulSet 'kube-public/volume' has 'spec.template.metadata.annotations'
+    And Kubernetes resource apps/v1/DaemonSet 'kube-public/env' has 'spec.template.metadata.annotations'
+    And Kubernetes resource apps/v1/Deployment 'kube-public/unrelated' doesn't have 'spec.template.metadata.annotations'
+    And a Normal 'RolloutTriggered' event is emitted on v1/Secret 'default/secret'
+
+  @update @rollout
+  Scenario: Secret without 'rollout-on-change' is updated
+    Given Kubernetes must have v1/Secret 'default/secret' with
+    """
+    metadata:
+      annotations:
+        secret.sync.klst.pw/namespace-selector: sync=secret
+    data:
+      username: bXktYXBw
+    """
+    And Kubernetes must have apps/v1/Deployment 'kube-public/env-from' with
+    """
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            envFrom:
+            - secretRef:
+                name: secret
+    """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+    """
+    data:
+      username: bmVvYWRtaW4K
+    """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource apps/v1/Deployment 'kube-public/env-from' doesn't have 'spec.template.metadata.annotations'
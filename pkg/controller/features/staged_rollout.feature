@@ -0,0 +1,243 @@
+@staged_rollout
+Feature: Staged rollout of secret's updates
+  Content changes of a secret should be rolled out
+  by batches over its owned secrets, canary namespaces first.
+
+  Background:
+    Given Kubernetes must have the following resources
+      | ApiGroupVersion | Kind      | Namespace | Name        |
+      | v1              | Namespace |           | kube-system |
+      | v1              | Namespace |           | kube-public |
+      | v1              | Namespace |           | default     |
+      | v1              | Namespace |           | team-a      |
+      | v1              | Namespace |           | team-b      |
+      | v1              | Namespace |           | team-c      |
+    And Kubernetes labelizes v1/Namespace 'kube-public' with 'sync=secret'
+    And Kubernetes labelizes v1/Namespace 'team-a' with 'sync=secret'
+    And Kubernetes labelizes v1/Namespace 'team-b' with 'sync=secret'
+    And Kubernetes labelizes v1/Namespace 'team-c' with 'sync=secret'
+    And Kubernetes labelizes v1/Namespace 'team-c' with 'canary=true'
+
+  @update
+  Scenario: Secret's content is rolled out by batches
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '2'
+          secret.sync.klst.pw/rollout-canary-selector: canary=true
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes has v1/Secret 'team-a/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'team-c/secret' is similar to 'default/secret'
+    But Kubernetes resource v1/Secret 'kube-public/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'team-b/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=1/4'
+    And a Normal 'RolloutBatch' event is emitted on v1/Secret 'default/secret'
+    And the reconciliation is requeued
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'team-a/secret' is similar to 'default/secret'
+    But Kubernetes resource v1/Secret 'team-b/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=3/4'
+    And the reconciliation is requeued
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'team-b/secret' is similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=4/4'
+    And the reconciliation is not requeued
+
+  @update
+  Scenario: Secret's content rollout waits between batches, even after a restart
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '1'
+          secret.sync.klst.pw/rollout-interval: 1h
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'kube-public/secret' is similar to 'default/secret'
+    But Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=1/4'
+    Given the controller restarts
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=1/4'
+    And the reconciliation is requeued
+    When the owned secret reconciler reconciles 'team-a/secret'
+    Then Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+
+  @update @resync
+  Scenario: Owned secrets not reached yet by the rollout have not drifted
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '1'
+          secret.sync.klst.pw/rollout-interval: 1h
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And the controller resyncs all secrets
+    Then 0 stale owned secret is detected
+    And Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=1/4'
+    When Kubernetes patches v1/Secret 'team-a/secret' with
+      """
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the controller resyncs all secrets
+    Then 1 stale owned secret is detected
+    And Kubernetes resource v1/Secret 'team-a/secret' has 'data.username=bXktYXBw'
+
+  @create
+  Scenario: New namespaces are not staged
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '1'
+          secret.sync.klst.pw/rollout-interval: 1h
+      """
+    When the secret reconciler reconciles 'default/secret'
+    Then Kubernetes has v1/Secret 'team-a/secret'
+    And Kubernetes has v1/Secret 'team-b/secret'
+    And Kubernetes has v1/Secret 'team-c/secret'
+    And Kubernetes has v1/Secret 'kube-public/secret'
+
+  @update
+  Scenario: Owned secret tampered during a rollout is restored to its previous content
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '1'
+          secret.sync.klst.pw/rollout-interval: 1h
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes patches v1/Secret 'team-a/secret' with
+      """
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the owned secret reconciler reconciles 'team-a/secret'
+    Then Kubernetes resource v1/Secret 'team-a/secret' has 'data.username=bXktYXBw'
+    And Kubernetes resource v1/Secret 'team-a/secret' is not similar to 'default/secret'
+    When Kubernetes patches v1/Secret 'team-b/secret' with
+      """
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'team-b/secret' has 'data.username=bXktYXBw'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=1/4'
+
+  @update
+  Scenario: Owned secret tampered with its source hash during a rollout is not staged
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '1'
+          secret.sync.klst.pw/rollout-interval: 1h
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    When Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes patches v1/Secret 'team-a/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/source-hash: tampered
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the owned secret reconciler reconciles 'team-a/secret'
+    Then Kubernetes resource v1/Secret 'team-a/secret' is similar to 'default/secret'
+    But Kubernetes resource v1/Secret 'team-b/secret' is not similar to 'default/secret'
+
+  @update
+  Scenario: Owned secret tampered after a rollout is restored
+    Given Kubernetes creates a new v1/Secret 'default/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/namespace-selector: sync=secret
+          secret.sync.klst.pw/rollout-batch-size: '4'
+      data:
+        username: bXktYXBw
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes patches v1/Secret 'default/secret' with
+      """
+      data:
+        username: bmVvYWRtaW4K
+      """
+    And the secret reconciler reconciles 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=4/4'
+    When Kubernetes patches v1/Secret 'team-a/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/source-hash: tampered
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the owned secret reconciler reconciles 'team-a/secret'
+    Then Kubernetes resource v1/Secret 'team-a/secret' is similar to 'default/secret'
+    When Kubernetes patches v1/Secret 'team-b/secret' with
+      """
+      metadata:
+        annotations:
+          secret.sync.klst.pw/source-hash: tampered
+      data:
+        username: dGFtcGVyZWQ=
+      """
+    And the secret reconciler reconciles 'default/secret'
+    Then Kubernetes resource v1/Secret 'team-b/secret' is similar to 'default/secret'
+    And Kubernetes resource v1/Secret 'default/secret' has annotation 'secret.sync.klst.pw/rollout-progress=4/4'
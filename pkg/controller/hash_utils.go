@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hashSecret computes a hash over all fields managed by the controller on an
+// owned secret: its data, its type and its managed metadata. The source hash
+// annotation itself is excluded in order to compare a live secret with
+// its desired state.
+func hashSecret(secret *corev1.Secret) string {
+	annotations := map[string]string{}
+	for key, value := range secret.Annotations {
+		if key != SourceHashAnnotationKey {
+			annotations[key] = value
+		}
+	}
+	labels := map[string]string{}
+	for key, value := range secret.Labels {
+		labels[key] = value
+	}
+	data := map[string][]byte{}
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+
+	// NOTE: json.Marshal sorts map keys, which makes the output deterministic.
+	//       Maps are always copied in order to hash nil and empty maps
+	//       the same way.
+	raw, _ := json.Marshal(struct {
+		Type            corev1.SecretType
+		Immutable       bool
+		Data            map[string][]byte
+		Labels          map[string]string
+		Annotations     map[string]string
+		OwnerReferences []metav1.OwnerReference
+	}{
+		Type:            secret.Type,
+		Immutable:       isImmutable(secret),
+		Data:            data,
+		Labels:          labels,
+		Annotations:     annotations,
+		OwnerReferences: secret.OwnerReferences,
+	})
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// isSynchronized returns true if the live owned secret is already identical to
+// the desired one; in this case, the owned secret doesn't need to be updated.
+func isSynchronized(secret, template *corev1.Secret) bool {
+	hash := template.Annotations[SourceHashAnnotationKey]
+	return secret.Annotations[SourceHashAnnotationKey] == hash && hashSecret(secret) == hash
+}
+
+// isImmutable returns true if the given secret is immutable.
+func isImmutable(secret *corev1.Secret) bool {
+	return secret.Immutable != nil && *secret.Immutable
+}
+
+// needsReplacement returns true if the live owned secret cannot be updated
+// to the desired one and must be replaced; the data of an immutable secret
+// cannot be updated and a secret cannot become mutable again.
+func needsReplacement(secret, template *corev1.Secret) bool {
+	if !isImmutable(secret) {
+		return false
+	}
+	if !isImmutable(template) {
+		return true
+	}
+	return (len(secret.Data) > 0 || len(template.Data) > 0) && !reflect.DeepEqual(secret.Data, template.Data)
+}
+
+// hasSameContent returns true if both secrets have the same type and data.
+func hasSameContent(secret, template *corev1.Secret) bool {
+	if secret.Type != template.Type || len(secret.Data) != len(template.Data) {
+		return false
+	}
+	return len(secret.Data) == 0 || reflect.DeepEqual(secret.Data, template.Data)
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// bootstrapRequeueAfter is the delay before a reconciliation received
+// before the end of the registry bootstrap is retried.
+const bootstrapRequeueAfter = time.Second
+
+type (
+	// healthProbes implements the readiness and the liveness checks of the
+	// controller.
+	// - The controller is ready when all informer caches are synchronized
+	//   and, once elected as leader, when the registry is bootstrapped;
+	//   standby replicas are ready as soon as their caches are synchronized.
+	// - The controller is alive while none of its workqueues is stuck (no
+	//   progress while items are pending).
+	healthProbes struct {
+		cacheSynced  func() bool
+		gatherer     prometheus.Gatherer
+		stuckTimeout time.Duration
+
+		elected      int32
+		bootstrapped int32
+
+		queues map[string]*queueProgress
+		mx     sync.Mutex
+	}
+
+	// queueProgress keeps the progress state of a single workqueue.
+	queueProgress struct {
+		lastProgress int64
+		pendingSince int64
+	}
+
+	// bootstrappedReconciler wraps a reconciler in order to delay all
+	// reconciliations until the registry is bootstrapped.
+	bootstrappedReconciler struct {
+		reconcile.Reconciler
+		bootstrapped *int32
+	}
+
+	// progressReconciler wraps a reconciler in order to record its progress.
+	progressReconciler struct {
+		reconcile.Reconciler
+		progress *queueProgress
+	}
+)
+
+func newHealthProbes(cacheSynced func() bool, gatherer prometheus.Gatherer, stuckTimeout time.Duration) *healthProbes {
+	return &healthProbes{
+		cacheSynced:  cacheSynced,
+		gatherer:     gatherer,
+		stuckTimeout: stuckTimeout,
+		queues:       map[string]*queueProgress{},
+	}
+}
+
+// Track wraps the given reconciler in order to watch the progress of
+// the workqueue with the given name.
+func (h *healthProbes) Track(name string, reconciler reconcile.Reconciler) reconcile.Reconciler {
+	progress := &queueProgress{lastProgress: time.Now().UnixNano()}
+
+	h.mx.Lock()
+	h.queues[name] = progress
+	h.mx.Unlock()
+	return &progressReconciler{Reconciler: reconciler, progress: progress}
+}
+
+// AfterBootstrap wraps the given reconciler in order to requeue all
+// reconciliations until the registry is bootstrapped; reconcilers rely on
+// the registry to find the owned secrets.
+func (h *healthProbes) AfterBootstrap(reconciler reconcile.Reconciler) reconcile.Reconciler {
+	return &bootstrappedReconciler{Reconciler: reconciler, bootstrapped: &h.bootstrapped}
+}
+
+// MarkElected marks the controller as elected leader.
+func (h *healthProbes) MarkElected() { atomic.StoreInt32(&h.elected, 1) }
+
+// MarkBootstrapped marks the registry as bootstrapped.
+func (h *healthProbes) MarkBootstrapped() { atomic.StoreInt32(&h.bootstrapped, 1) }
+
+// Readiness implements the readiness check.
+func (h *healthProbes) Readiness(_ *http.Request) error {
+	switch {
+	case !h.cacheSynced():
+		return fmt.Errorf("informer caches not synchronized")
+	case atomic.LoadInt32(&h.elected) == 1 && atomic.LoadInt32(&h.bootstrapped) == 0:
+		return fmt.Errorf("registry bootstrap not completed")
+	}
+	return nil
+}
+
+// Liveness implements the liveness check.
+func (h *healthProbes) Liveness(_ *http.Request) error {
+	depths, err := h.workqueueDepths()
+	if err != nil {
+		return fmt.Errorf("failed to gather workqueue metrics: %w", err)
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	now := time.Now()
+	for name, progress := range h.queues {
+		if depths[name] == 0 {
+			progress.pendingSince = 0
+			continue
+		}
+		if progress.pendingSince == 0 {
+			progress.pendingSince = now.UnixNano()
+		}
+
+		// NOTE: the workqueue is stuck if items are pending and nothing has
+		//       been reconciled since they are pending
+		since := atomic.LoadInt64(&progress.lastProgress)
+		if progress.pendingSince > since {
+			since = progress.pendingSince
+		}
+		if stuck := now.Sub(time.Unix(0, since)); stuck > h.stuckTimeout {
+			return fmt.Errorf("workqueue %s stuck: %v items pending without progress since %s", name, depths[name], stuck)
+		}
+	}
+	return nil
+}
+
+// workqueueDepths returns the current depth of all workqueues, based on the
+// controller-runtime metrics.
+func (h *healthProbes) workqueueDepths() (map[string]float64, error) {
+	families, err := h.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	depths := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "workqueue_depth" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "name" {
+					depths[label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return depths, nil
+}
+
+func (r *progressReconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	defer func() { atomic.StoreInt64(&r.progress.lastProgress, time.Now().UnixNano()) }()
+	return r.Reconciler.Reconcile(req)
+}
+
+func (r *bootstrappedReconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	if atomic.LoadInt32(r.bootstrapped) == 0 {
+		return reconcile.Result{RequeueAfter: bootstrapRequeueAfter}, nil
+	}
+	return r.Reconciler.Reconcile(req)
+}
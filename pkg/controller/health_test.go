@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestHealthProbes_Readiness(t *testing.T) {
+	synced := false
+	probes := newHealthProbes(func() bool { return synced }, prometheus.NewRegistry(), time.Minute)
+
+	assert.EqualError(t, probes.Readiness(nil), "informer caches not synchronized")
+	synced = true
+	// NOTE: standby replicas are ready once their caches are synchronized
+	assert.NoError(t, probes.Readiness(nil))
+	probes.MarkElected()
+	assert.EqualError(t, probes.Readiness(nil), "registry bootstrap not completed")
+	probes.MarkBootstrapped()
+	assert.NoError(t, probes.Readiness(nil))
+}
+
+func TestHealthProbes_AfterBootstrap(t *testing.T) {
+	probes := newHealthProbes(func() bool { return true }, prometheus.NewRegistry(), time.Minute)
+	reconciled := 0
+	reconciler := probes.AfterBootstrap(reconcile.Func(func(reconcile.Request) (reconcile.Result, error) {
+		reconciled++
+		return reconcile.Result{}, nil
+	}))
+
+	result, err := reconciler.Reconcile(reconcile.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, bootstrapRequeueAfter, result.RequeueAfter)
+	assert.Equal(t, 0, reconciled)
+
+	probes.MarkBootstrapped()
+	result, err = reconciler.Reconcile(reconcile.Request{})
+	assert.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Equal(t, 1, reconciled)
+}
+
+func TestHealthProbes_Liveness(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "workqueue_depth"}, []string{"name"})
+	registry.MustRegister(depth)
+
+	probes := newHealthProbes(func() bool { return true }, registry, 10*time.Millisecond)
+	reconciler := probes.Track("test", reconcile.Func(func(reconcile.Request) (reconcile.Result, error) {
+		return reconcile.Result{}, nil
+	}))
+	time.Sleep(20 * time.Millisecond)
+
+	t.Run("WithoutPendingItems", func(t *testing.T) {
+		depth.WithLabelValues("test").Set(0)
+		assert.NoError(t, probes.Liveness(nil))
+	})
+
+	t.Run("WithRecentPendingItems", func(t *testing.T) {
+		depth.WithLabelValues("test").Set(1)
+		assert.NoError(t, probes.Liveness(nil))
+	})
+
+	t.Run("WithStuckPendingItems", func(t *testing.T) {
+		time.Sleep(20 * time.Millisecond)
+		assert.Error(t, probes.Liveness(nil))
+	})
+
+	t.Run("WithProgress", func(t *testing.T) {
+		_, _ = reconciler.Reconcile(reconcile.Request{})
+		assert.NoError(t, probes.Liveness(nil))
+	})
+}
@@ -2,9 +2,12 @@ package controller
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -17,10 +20,61 @@ const (
 	// origin annotations (based on the idea of github.com/appscode/kubed)
 	OriginNameLabelsKey      = "secret.sync.klst.pw/origin.name"
 	OriginNamespaceLabelsKey = "secret.sync.klst.pw/origin.namespace"
+
+	// synchronization state annotations
+	SourceHashAnnotationKey = "secret.sync.klst.pw/source-hash"
+
+	// versioned name annotation, used to suffix the name of the owned
+	// secrets with a short hash of their content
+	VersionedNameAnnotationKey = "secret.sync.klst.pw/versioned-name"
 )
 
 // listNamespacesFromAnnotations lists all namespaces based on the secret annotations.
 func listNamespacesFromAnnotations(ctx *Context, secret corev1.Secret) ([]string, error) {
+	options, err := parseSyncAnnotations(secret)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.cluster != "" {
+		// NOTE: the namespaces of a remote cluster are only selected by the
+		//       namespace selector of this remote cluster
+		options = []client.ListOption{client.MatchingLabelsSelector{Selector: ctx.clusterNamespaceSelector}}
+	}
+
+	namespaceObjects, err := listNamespaces(ctx, options...)
+	if err != nil {
+		return nil, ClientError{fmt.Errorf("failed to list namespaces: %w", err)}
+	}
+
+	namespaces := make([]string, 0, len(namespaceObjects))
+	syncedAt := contentSyncedAt(ctx, secret)
+	for _, namespace := range namespaceObjects {
+		if (ctx.cluster != "" || namespace.Name != secret.Namespace) && !isIgnoredNamespace(ctx, namespace) && !isExpired(secret, syncedAt, namespace) {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+
+	if ctx.AuthorizeAnnotator {
+		return filterAuthorizedNamespaces(ctx, secret, namespaces)
+	}
+	return namespaces, nil
+}
+
+// ValidateSyncAnnotations validates the synchronization annotations of the
+// given secret. It returns an AnnotationError if these annotations are
+// malformed or conflicting.
+func ValidateSyncAnnotations(secret corev1.Secret) error {
+	_, err := parseSyncAnnotations(secret)
+	if _, noAnnotation := err.(NoAnnotationError); noAnnotation {
+		return nil
+	}
+	return err
+}
+
+// parseSyncAnnotations parses the synchronization annotations of the given
+// secret and returns the options required to list the namespaces where the
+// secret must be synchronized.
+func parseSyncAnnotations(secret corev1.Secret) ([]client.ListOption, error) {
 	var options []client.ListOption
 
 	allNamespaces, hasAllNamespace := secret.Annotations[NamespaceAllAnnotationKey]
@@ -46,27 +100,62 @@ func listNamespacesFromAnnotations(ctx *Context, secret corev1.Secret) ([]string
 		err = NoAnnotationError{fmt.Errorf("no annotation found, ignore synchronization")}
 	}
 
-	if err != nil {
-		return nil, err
+	if policy, exists := secret.Annotations[ConflictPolicyAnnotationKey]; err == nil && exists {
+		if perr := ValidateConflictPolicy(policy); perr != nil {
+			err = AnnotationError{fmt.Errorf("'%s' is invalid: %w", ConflictPolicyAnnotationKey, perr)}
+		}
 	}
-
-	namespaceObjects := &corev1.NamespaceList{}
-	if err := ctx.client.List(ctx, namespaceObjects, options...); err != nil {
-		return nil, ClientError{fmt.Errorf("failed to list namespaces: %w", err)}
+	for _, key := range []string{PausedAnnotationKey, DryRunAnnotationKey, RolloutOnChangeAnnotationKey, VersionedNameAnnotationKey, StableNameAnnotationKey} {
+		if value, exists := secret.Annotations[key]; err == nil && exists {
+			if _, perr := strconv.ParseBool(value); perr != nil {
+				err = AnnotationError{fmt.Errorf("'%s' is not a boolean", key)}
+			}
+		}
+	}
+	if _, perr := parseRolloutStrategy(secret); err == nil && perr != nil {
+		err = AnnotationError{perr}
+	}
+	if _, perr := parseExpiration(secret.ObjectMeta, time.Time{}); err == nil && perr != nil {
+		err = AnnotationError{perr}
+	}
+	if _, perr := parseVersionHistory(secret); err == nil && perr != nil {
+		err = AnnotationError{perr}
 	}
+	if policy, exists := secret.Annotations[DeletionPolicyAnnotationKey]; err == nil && exists {
+		if perr := ValidateDeletionPolicy(policy); perr != nil {
+			err = AnnotationError{fmt.Errorf("'%s' is invalid: %w", DeletionPolicyAnnotationKey, perr)}
+		}
+	}
+	return options, err
+}
 
-	ignoredNamespace := map[string]struct{}{}
-	for _, namespace := range append(ctx.IgnoredNamespaces, secret.Namespace) {
-		ignoredNamespace[namespace] = struct{}{}
+// newOwnedSecretTemplate generates the desired state of all secrets owned by
+// the given secret. The returned template is stamped with the hash of its
+// content and must be copied before being used in a specific namespace.
+func newOwnedSecretTemplate(ctx *Context, owner *corev1.Secret) *corev1.Secret {
+	template := owner.DeepCopy()
+	template.ObjectMeta = metav1.ObjectMeta{
+		Name:        template.Name,
+		Labels:      template.Labels,
+		Annotations: template.Annotations,
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "Secret", Name: owner.Name, UID: owner.UID},
+		},
 	}
+	template = assignOriginMetadata(template, owner)
+	template = excludeProtectedMetadata(ctx, template)
+	template.Annotations[SourceHashAnnotationKey] = hashSecret(template)
 
-	namespaces := make([]string, 0, len(namespaceObjects.Items))
-	for _, namespace := range namespaceObjects.Items {
-		if _, exists := ignoredNamespace[namespace.Name]; !exists {
-			namespaces = append(namespaces, namespace.Name)
-		}
+	if isVersioned(*owner) {
+		template.Name = versionedName(owner.Name, template.Annotations[SourceHashAnnotationKey])
 	}
-	return namespaces, nil
+	return template
+}
+
+// versionedName returns the name of an owned secret suffixed by a short
+// version of its hash, so consumers can roll between versions.
+func versionedName(name, hash string) string {
+	return fmt.Sprintf("%s-%s", name, hash[:8])
 }
 
 // assignOriginMetadata assign to the secret some metadata that come from the
@@ -91,6 +180,26 @@ func assignOriginMetadata(secret, origin *corev1.Secret) *corev1.Secret {
 func excludeProtectedMetadata(ctx *Context, secret *corev1.Secret) *corev1.Secret {
 	delete(secret.Annotations, NamespaceAllAnnotationKey)
 	delete(secret.Annotations, NamespaceSelectorAnnotationKey)
+	delete(secret.Annotations, SourceHashAnnotationKey)
+	delete(secret.Annotations, VersionedNameAnnotationKey)
+	delete(secret.Annotations, VersionHistoryAnnotationKey)
+	delete(secret.Annotations, StableNameAnnotationKey)
+	delete(secret.Annotations, RemoteClustersAnnotationKey)
+	delete(secret.Annotations, ConflictPolicyAnnotationKey)
+	delete(secret.Annotations, DeletionPolicyAnnotationKey)
+	delete(secret.Annotations, PausedAnnotationKey)
+	delete(secret.Annotations, DryRunAnnotationKey)
+	delete(secret.Annotations, RolloutOnChangeAnnotationKey)
+	delete(secret.Annotations, RolloutBatchSizeAnnotationKey)
+	delete(secret.Annotations, RolloutIntervalAnnotationKey)
+	delete(secret.Annotations, RolloutCanarySelectorAnnotationKey)
+	delete(secret.Annotations, ExpiresAtAnnotationKey)
+	delete(secret.Annotations, TTLAnnotationKey)
+	delete(secret.Annotations, AnnotatedByAnnotationKey)
+	delete(secret.Annotations, AnnotatedByGroupsAnnotationKey)
+	for _, annotation := range syncStatusAnnotationKeys {
+		delete(secret.Annotations, annotation)
+	}
 	for _, annotation := range ctx.ProtectedAnnotations {
 		delete(secret.Annotations, annotation)
 	}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+const metricsNamespace = "sync_secrets_controller"
+
+// owned secret operations, used as metric label
+const (
+	createOperation = "create"
+	updateOperation = "update"
+	deleteOperation = "delete"
+)
+
+var (
+	ownedSecretOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "owned_secret_operations_total",
+			Help:      "Total number of operations (create, update, delete) done on owned secrets, per namespace.",
+		},
+		[]string{"operation", "namespace"},
+	)
+	ownedSecretFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "owned_secret_failures_total",
+			Help:      "Total number of failed operations (create, update, delete) on owned secrets, per namespace and error type.",
+		},
+		[]string{"operation", "namespace", "error_type"},
+	)
+	ownedSecretOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "owned_secret_operation_duration_seconds",
+			Help:      "Duration of operations (create, update, delete) done on owned secrets.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"operation"},
+	)
+	ownedSecretDryRunOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "owned_secret_dry_run_operations_total",
+			Help:      "Total number of operations (create, update, delete) which would have been done on owned secrets without dry-run, per namespace.",
+		},
+		[]string{"operation", "namespace"},
+	)
+	driftedOwnedSecretsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "drifted_owned_secrets_total",
+			Help:      "Total number of drifted owned secrets (missing, stale, orphaned) detected by the periodic resync.",
+		},
+		[]string{"type"},
+	)
+	resyncDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "resync_duration_seconds",
+			Help:      "Duration of the periodic resyncs.",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	lastResyncTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_resync_timestamp_seconds",
+			Help:      "Timestamp of the last periodic resync.",
+		},
+	)
+	remoteClusterUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "remote_cluster_up",
+			Help:      "Whether the remote cluster is reachable (1) or not (0), per remote cluster.",
+		},
+		[]string{"cluster"},
+	)
+	droppedAuditRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_audit_records_total",
+			Help:      "Total number of audit records which have not been written, per reason (queue_full, write_failed).",
+		},
+		[]string{"reason"},
+	)
+	syncLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_latency_seconds",
+			Help:      "Latency between the last change of a managed secret and the last owned secret written.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		ownedSecretOperationsTotal,
+		ownedSecretFailuresTotal,
+		ownedSecretOperationDuration,
+		ownedSecretDryRunOperationsTotal,
+		driftedOwnedSecretsTotal,
+		resyncDuration,
+		lastResyncTimestamp,
+		remoteClusterUp,
+		droppedAuditRecordsTotal,
+		syncLatency,
+	)
+}
+
+var (
+	// registryMetrics is the registry whose state is reported by the
+	// registry gauges; these gauges are registered once, so several
+	// controllers can be created in the same process.
+	registryMetrics     *registry.Registry
+	registryMetricsMx   sync.RWMutex
+	registryMetricsOnce sync.Once
+)
+
+// registerRegistryMetrics registers all gauges based on the registry state.
+// Only the last registered registry is reported.
+func registerRegistryMetrics(registry *registry.Registry) {
+	registryMetricsMx.Lock()
+	registryMetrics = registry
+	registryMetricsMx.Unlock()
+
+	registryMetricsOnce.Do(func() {
+		metrics.Registry.MustRegister(
+			prometheus.NewGaugeFunc(
+				prometheus.GaugeOpts{
+					Namespace: metricsNamespace,
+					Name:      "managed_secrets",
+					Help:      "Number of secrets managed by the controller.",
+				},
+				func() float64 { return float64(len(currentRegistryMetrics().Secrets())) },
+			),
+			prometheus.NewGaugeFunc(
+				prometheus.GaugeOpts{
+					Namespace: metricsNamespace,
+					Name:      "owned_secrets",
+					Help:      "Number of secrets owned (copied) by the controller.",
+				},
+				func() float64 { return float64(len(currentRegistryMetrics().OwnedSecrets())) },
+			),
+			prometheus.NewGaugeFunc(
+				prometheus.GaugeOpts{
+					Namespace: metricsNamespace,
+					Name:      "conflicting_secrets",
+					Help:      "Number of pre-existing secrets which conflict with a managed secret.",
+				},
+				func() float64 { return float64(len(currentRegistryMetrics().Conflicts())) },
+			),
+		)
+	})
+}
+
+// currentRegistryMetrics returns the registry reported by the registry gauges.
+func currentRegistryMetrics() *registry.Registry {
+	registryMetricsMx.RLock()
+	defer registryMetricsMx.RUnlock()
+	return registryMetrics
+}
+
+// observeOperation records the result of an operation done on an owned secret.
+func observeOperation(operation, namespace string, start time.Time, err error) {
+	ownedSecretOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ownedSecretFailuresTotal.WithLabelValues(operation, namespace, errorType(err)).Inc()
+		return
+	}
+	ownedSecretOperationsTotal.WithLabelValues(operation, namespace).Inc()
+}
+
+// observeSyncLatency records the latency between the last change of the
+// given secret and now.
+func observeSyncLatency(secret corev1.Secret) {
+	syncLatency.Observe(time.Since(lastChangeTime(secret)).Seconds())
+}
+
+// lastChangeTime returns the last time the given secret was modified, based
+// on its managed fields. If no managed field is available, the creation
+// time is used.
+func lastChangeTime(secret corev1.Secret) time.Time {
+	last := secret.CreationTimestamp
+	for _, field := range secret.ManagedFields {
+		if field.Time != nil && last.Before(field.Time) {
+			last = metav1.Time{Time: field.Time.Time}
+		}
+	}
+	return last.Time
+}
+
+// errorType returns a short representation of the error, used as
+// metric label.
+func errorType(err error) string {
+	if reason := errors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return string(reason)
+	}
+	return "Unknown"
+}
@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterRegistryMetrics(t *testing.T) {
+	// NOTE: several controllers can be created in the same process; the
+	//       registry gauges report the last one
+	assert.NotPanics(t, func() { NewController(Options{}, Context{}) })
+	controller := NewController(Options{}, Context{})
+	assert.Equal(t, controller.registry, currentRegistryMetrics())
+}
@@ -1,8 +1,8 @@
 package controller
 
 import (
-	"github.com/thoas/go-funk"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -10,23 +10,49 @@ import (
 type NamespaceReconciler struct{ *Context }
 
 func (n *NamespaceReconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
-	if funk.ContainsString(n.IgnoredNamespaces, req.Name) {
+	if isIgnoredNamespaceName(n.Context, req.Name) {
 		klog.V(3).Infof("namespace %s is ignored, ignore synchronization of %T %s", req.Name, corev1.Namespace{}, req.Name)
 		return reconcile.Result{}, nil
 	}
 
 	reconciler := &SecretReconciler{n.Context}
-	emptyResult := reconcile.Result{}
 
 	secrets := n.registry.Secrets()
+
+	namespace := corev1.Namespace{}
+	err := getNamespace(n.Context, req.Name, &namespace)
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("failed to fetch %T %s: %s... retry after %s", namespace, req, err, requeueAfter)
+		return reconcile.Result{RequeueAfter: requeueAfter}, err
+	} else if err == nil && isIgnoredNamespace(n.Context, namespace) {
+		// NOTE: no secret can be synchronized in this namespace; only secrets
+		//       already synchronized in it must be reconciled, in order to
+		//       remove their owned secrets
+		klog.V(3).Infof("%T %s refuses synchronized secrets", namespace, req.Name)
+		secrets = nil
+		for _, owned := range n.registry.OwnedSecrets() {
+			if owner := n.registry.SecretWithOwnedSecretName(owned); owned.Namespace == req.Name && owner != nil {
+				secrets = append(secrets, owner.NamespacedName)
+			}
+		}
+	}
+
+	// NOTE: secrets requeued after a delay (staged rollout, expiration) are
+	//       not failures; the namespace is requeued after the smallest one
+	result := reconcile.Result{}
 	klog.V(3).Infof("reconcile all synchronized secrets: %v", secrets)
 	for _, namespacedName := range secrets {
 		res, err := reconciler.Reconcile(reconcile.Request{NamespacedName: namespacedName})
-		if err != nil || res != emptyResult {
+		if err != nil {
 			klog.Errorf("failed to reconcile %T %s", corev1.Namespace{}, req)
+			secret := &corev1.Secret{}
+			if getErr := n.client.Get(n.Context, namespacedName, secret); getErr == nil {
+				n.recorder.Eventf(secret, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to synchronize secret in namespace %s: %s", req.Name, err)
+			}
 			return res, err
 		}
+		result.RequeueAfter = minDelay(result.RequeueAfter, res.RequeueAfter)
 	}
 
-	return reconcile.Result{}, nil
+	return result, nil
 }
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IgnoreNamespaceLabelKey is the label used by namespace owners to refuse
+// all synchronized secrets.
+const IgnoreNamespaceLabelKey = "secret.sync.klst.pw/ignore"
+
+// ValidateNamespacePatterns validates all glob patterns of ignored
+// namespaces.
+func ValidateNamespacePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isIgnoredNamespaceName returns true if the given namespace name matches
+// one of the ignored namespace patterns.
+func isIgnoredNamespaceName(ctx *Context, name string) bool {
+	for _, pattern := range ctx.IgnoredNamespaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnoredNamespace returns true if no secret must be synchronized into the
+// given namespace, because it is ignored by the controller configuration or
+// because its owners refuse synchronized secrets.
+func isIgnoredNamespace(ctx *Context, namespace corev1.Namespace) bool {
+	switch {
+	case isIgnoredNamespaceName(ctx, namespace.Name):
+		return true
+	case ctx.IgnoredNamespaceSelector != nil && ctx.IgnoredNamespaceSelector.Matches(labels.Set(namespace.Labels)):
+		return true
+	}
+	return strings.ToLower(namespace.Labels[IgnoreNamespaceLabelKey]) == "true"
+}
+
+// isIgnoredSourceNamespace returns true if the secrets of the given
+// namespace must not be synchronized, because it is ignored by the
+// controller configuration.
+func isIgnoredSourceNamespace(ctx *Context, name string) (bool, error) {
+	if isIgnoredNamespaceName(ctx, name) {
+		return true, nil
+	}
+	if ctx.IgnoredNamespaceSelector == nil {
+		return false, nil
+	}
+
+	namespace := corev1.Namespace{}
+	err := getNamespace(ctx, name, &namespace)
+	switch {
+	case errors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, ClientError{fmt.Errorf("failed to fetch %T %s: %w", namespace, name, err)}
+	}
+	return ctx.IgnoredNamespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// getNamespace fetches the namespace with the given name.
+func getNamespace(ctx *Context, name string, namespace *corev1.Namespace) error {
+	return ctx.namespaceReader.Get(ctx, types.NamespacedName{Name: name}, namespace)
+}
+
+// listNamespaces lists all namespaces matching the given options. In
+// namespace-scoped mode, only the watched namespaces are fetched one by one,
+// because listing namespaces requires cluster-wide permissions.
+func listNamespaces(ctx *Context, options ...client.ListOption) ([]corev1.Namespace, error) {
+	if len(ctx.WatchNamespaces) == 0 {
+		namespaces := &corev1.NamespaceList{}
+		if err := ctx.namespaceReader.List(ctx, namespaces, options...); err != nil {
+			return nil, err
+		}
+		return namespaces.Items, nil
+	}
+
+	listOptions := (&client.ListOptions{}).ApplyOptions(options)
+	namespaces := make([]corev1.Namespace, 0, len(ctx.WatchNamespaces))
+	for _, name := range ctx.WatchNamespaces {
+		namespace := corev1.Namespace{}
+		err := getNamespace(ctx, name, &namespace)
+		switch {
+		case errors.IsNotFound(err):
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		if listOptions.LabelSelector == nil || listOptions.LabelSelector.Matches(labels.Set(namespace.Labels)) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces, nil
+}
@@ -5,14 +5,29 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type OwnedSecretReconcilier struct{ *Context }
 
+// enqueueOwnedSecret enqueues the owned secret itself (and not its owner),
+// because the name of an owned secret can differ from the name of its owner
+// (versioned name).
+var enqueueOwnedSecret = &handler.EnqueueRequestsFromMapFunc{
+	ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+		for _, reference := range obj.Meta.GetOwnerReferences() {
+			if reference.APIVersion == "v1" && reference.Kind == "Secret" {
+				name := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}
+				return []reconcile.Request{{NamespacedName: name}}
+			}
+		}
+		return nil
+	}),
+}
+
 func (r *OwnedSecretReconcilier) Reconcile(req reconcile.Request) (reconcile.Result, error) {
 	owned := corev1.Secret{}
 	klog.Infof("reconcile owned %T %s", owned, req)
@@ -30,7 +45,7 @@ func (r *OwnedSecretReconcilier) Reconcile(req reconcile.Request) (reconcile.Res
 		return reconcile.Result{RequeueAfter: requeueAfter}, err
 	}
 
-	err = SynchronizeOwnedSecret(r.Context, owner, req.Namespace)
+	err = SynchronizeOwnedSecret(r.Context, owner, req.NamespacedName)
 	if err == nil {
 		return reconcile.Result{}, nil
 	}
@@ -46,46 +61,125 @@ func (r *OwnedSecretReconcilier) Reconcile(req reconcile.Request) (reconcile.Res
 	}
 }
 
-// SynchronizeOwnedSecret duplicates the given secret in the given namespace.
-func SynchronizeOwnedSecret(ctx *Context, ownerSecret corev1.Secret, namespace string) error {
-	name := types.NamespacedName{Namespace: namespace, Name: ownerSecret.Name}
-	template := ownerSecret.DeepCopy()
-	template.ObjectMeta = metav1.ObjectMeta{
-		Name:        template.Name,
-		Namespace:   namespace,
-		Labels:      template.Labels,
-		Annotations: template.Annotations,
-		OwnerReferences: []metav1.OwnerReference{
-			{APIVersion: "v1", Kind: "Secret", Name: ownerSecret.Name, UID: ownerSecret.UID},
-		},
+// SynchronizeOwnedSecret restores the owned secret with the given name from
+// the given secret (or from one of its previous versions).
+func SynchronizeOwnedSecret(ctx *Context, ownerSecret corev1.Secret, name types.NamespacedName) error {
+	if isDryRun(ctx, ownerSecret) && !ctx.simulation {
+		ctx = newDryRunContext(ctx)
+	}
+
+	namespace := name.Namespace
+	template := ownedSecretTemplate(ctx, ownerSecret, name.Name)
+	if template == nil {
+		// NOTE: owned secrets which are no longer used (versioned name not
+		//       kept) are pruned by the owner reconciliation
+		klog.V(3).Infof("%T %s is no longer used by %s/%s, ignore it", ownerSecret, name, ownerSecret.Namespace, ownerSecret.Name)
+		return nil
+	}
+	template.Namespace = namespace
+
+	if isPaused(ctx, ownerSecret) {
+		klog.V(3).Infof("synchronization of %T %s/%s is paused, ignore %s", ownerSecret, ownerSecret.Namespace, ownerSecret.Name, name)
+		return nil
+	}
+
+	// NOTE: owned secrets of denied secrets are never restored; they are
+	//       pruned by the owner reconciliation, which reports the denial
+	quiet := *ctx
+	quiet.recorder = discardRecorder{}
+	if err := checkSourcePolicy(&quiet, ownerSecret); err != nil {
+		if _, denied := err.(PolicyError); denied {
+			klog.V(3).Infof("%T %s/%s is denied, ignore %s: %s", ownerSecret, ownerSecret.Namespace, ownerSecret.Name, name, err)
+			return nil
+		}
+		return err
+	}
+	if expired, err := isExpiredInNamespace(ctx, ownerSecret, namespace); err != nil {
+		return err
+	} else if expired {
+		// NOTE: expired owned secrets are removed by the owner reconciliation
+		klog.V(3).Infof("%T %s has expired, ignore it", ownerSecret, name)
+		return nil
 	}
-	template = assignOriginMetadata(template, &ownerSecret)
-	template = excludeProtectedMetadata(ctx, template)
 
 	secret := corev1.Secret{}
 	klog.V(3).Infof("fetch %T %s", secret, name)
 	err := ctx.client.Get(ctx, name, &secret)
 	if errors.IsNotFound(err) {
 		klog.V(3).Infof("%T %s not found, create it", secret, name)
-		if err = ctx.client.Create(ctx, template); err != nil {
+		if err = createOwnedSecret(ctx, template, RestoredReason); err != nil {
+			ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to restore owned secret %s: %s", name, err)
 			return ClientError{fmt.Errorf("failed to create %T %s: %w", secret, name, err)}
 		}
+		ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeNormal, RestoredReason, "Owned secret %s restored", name)
+		ctx.recorder.Eventf(template, corev1.EventTypeNormal, RestoredReason, "Secret restored from %s/%s", ownerSecret.Namespace, ownerSecret.Name)
 		return nil
 	} else if err != nil {
 		return ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)}
 	}
 
+	if isSynchronized(&secret, template) {
+		klog.V(5).Infof("%T %s already synchronized, ignore update", secret, name)
+		return nil
+	}
+
+	changed := !hasSameContent(&secret, template)
+	if strategy, _ := parseRolloutStrategy(ownerSecret); changed && strategy != nil && ctx.cluster == "" {
+		// NOTE: owned secrets not reached yet by a staged rollout keep their
+		//       previous content; they are updated by the rollout itself
+		owned, err := listOwnedSecrets(ctx, ownerSecret)
+		if err != nil {
+			return err
+		}
+		secrets := make([]*corev1.Secret, 0, len(owned))
+		for i := range owned {
+			secrets = append(secrets, &owned[i])
+		}
+
+		if previous, pending := previousTemplates(secrets, template)[secret.Annotations[SourceHashAnnotationKey]]; pending {
+			if isSynchronized(&secret, previous) {
+				klog.V(3).Infof("%T %s not reached yet by the rollout, ignore update", secret, name)
+				return nil
+			}
+			klog.V(3).Infof("%T %s not reached yet by the rollout, restore its previous content", secret, name)
+			template = previous
+			template.Namespace = namespace
+			changed = !hasSameContent(&secret, template)
+		}
+	}
+
+	if needsReplacement(&secret, template) {
+		klog.V(3).Infof("%T %s is immutable, replace it", secret, name)
+		if err = replaceOwnedSecret(ctx, &secret, template, RestoredReason); err != nil {
+			ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to restore owned secret %s: %s", name, err)
+			return ClientError{fmt.Errorf("failed to replace %T %s: %w", secret, name, err)}
+		}
+		ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeNormal, RestoredReason, "Owned secret %s restored", name)
+		ctx.recorder.Eventf(template, corev1.EventTypeNormal, RestoredReason, "Secret restored from %s/%s", ownerSecret.Namespace, ownerSecret.Name)
+		if changed && isRolloutOnChange(ownerSecret) {
+			return rolloutWorkloads(ctx, ownerSecret, template, changed)
+		}
+		return nil
+	}
+
 	secret.SetName(template.GetName())
 	secret.SetNamespace(namespace)
 	secret.SetLabels(template.GetLabels())
 	secret.SetAnnotations(template.GetAnnotations())
 	secret.SetOwnerReferences(template.GetOwnerReferences())
+	secret.Immutable = template.Immutable
 	secret.StringData = template.StringData
 	secret.Data = template.Data
 
 	klog.V(3).Infof("update %T %s", ownerSecret, name)
-	if err = ctx.client.Update(ctx, &secret); err != nil {
+	if err = updateOwnedSecret(ctx, &secret, RestoredReason); err != nil {
+		ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to restore owned secret %s: %s", name, err)
 		return ClientError{fmt.Errorf("failed to update %T %s: %w", ownerSecret, name, err)}
 	}
+	ctx.recorder.Eventf(&ownerSecret, corev1.EventTypeNormal, RestoredReason, "Owned secret %s restored", name)
+	ctx.recorder.Eventf(&secret, corev1.EventTypeNormal, RestoredReason, "Secret restored from %s/%s", ownerSecret.Namespace, ownerSecret.Name)
+	if changed && isRolloutOnChange(ownerSecret) {
+		return rolloutWorkloads(ctx, ownerSecret, &secret, changed)
+	}
 	return nil
 }
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PausedAnnotationKey is the annotation used to pause the synchronization of
+// a secret; its owned secrets are no longer written until it is unpaused.
+const PausedAnnotationKey = "secret.sync.klst.pw/paused"
+
+// isPaused returns true if the synchronization of the given managed secret
+// is paused, globally or through its annotation.
+func isPaused(ctx *Context, secret corev1.Secret) bool {
+	if ctx.Paused {
+		return true
+	}
+	paused, _ := strconv.ParseBool(secret.Annotations[PausedAnnotationKey])
+	return paused
+}
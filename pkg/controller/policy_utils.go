@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// checkSourcePolicy checks if the given annotated secret is allowed to be
+// synchronized by the controller configuration. A Warning event is emitted
+// and a PolicyError is returned if the secret is denied.
+func checkSourcePolicy(ctx *Context, secret corev1.Secret) error {
+	if !hasSyncAnnotations(secret) {
+		return nil
+	}
+
+	if funk.ContainsString(ctx.DeniedSecretTypes, string(secret.Type)) {
+		err := PolicyError{fmt.Errorf("secret type '%s' cannot be synchronized", secret.Type)}
+		ctx.recorder.Event(&secret, corev1.EventTypeWarning, SecretTypeDeniedReason, err.Error())
+		return err
+	}
+
+	allowed, err := isAllowedSourceNamespace(ctx, secret.Namespace)
+	if err != nil {
+		return err
+	} else if !allowed {
+		err := PolicyError{fmt.Errorf("namespace %s is not allowed to publish synchronized secrets", secret.Namespace)}
+		ctx.recorder.Event(&secret, corev1.EventTypeWarning, SourceNamespaceDeniedReason, err.Error())
+		return err
+	}
+	return nil
+}
+
+// isAllowedSourceNamespace returns true if the secrets of the given
+// namespace are allowed to be synchronized. All namespaces are allowed if no
+// source namespace is configured.
+func isAllowedSourceNamespace(ctx *Context, name string) (bool, error) {
+	if len(ctx.SourceNamespaces) == 0 && ctx.SourceNamespaceSelector == nil {
+		return true, nil
+	}
+
+	for _, pattern := range ctx.SourceNamespaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true, nil
+		}
+	}
+	if ctx.SourceNamespaceSelector == nil {
+		return false, nil
+	}
+
+	namespace := corev1.Namespace{}
+	err := getNamespace(ctx, name, &namespace)
+	switch {
+	case errors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, ClientError{fmt.Errorf("failed to fetch %T %s: %w", namespace, name, err)}
+	}
+	return ctx.SourceNamespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ignoreSyncStatusUpdates filters all secret updates which only modify the
+// synchronization status annotations; these updates are done by the
+// controller itself and must not trigger a new reconciliation.
+var ignoreSyncStatusUpdates = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldSecret, isSecret := e.ObjectOld.(*corev1.Secret)
+		if !isSecret {
+			return true
+		}
+		newSecret, isSecret := e.ObjectNew.(*corev1.Secret)
+		if !isSecret {
+			return true
+		}
+
+		oldSecret, newSecret = withoutSyncStatus(oldSecret), withoutSyncStatus(newSecret)
+		oldSecret.ResourceVersion, newSecret.ResourceVersion = "", ""
+		oldSecret.ManagedFields, newSecret.ManagedFields = nil, nil
+		return !equality.Semantic.DeepEqual(oldSecret, newSecret)
+	},
+}
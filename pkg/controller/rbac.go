@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// inClusterNamespaceFile is the file containing the namespace of the
+// controller when it runs inside a cluster.
+var inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// NamespacedRBACManifests generates the Roles and RoleBindings required by
+// the controller in namespace-scoped mode, replacing the ClusterRoles
+// defined in deploy/rbac.yaml (and deploy/rbac-rollout.yaml if rollout is
+// set).
+func NamespacedRBACManifests(namespaces []string, serviceAccount string, rollout, leaderElection bool, leaderElectionNamespace string) ([]byte, error) {
+	saNamespace, saName, err := parseServiceAccount(serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	subject := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: saNamespace, Name: saName}
+	if leaderElection && leaderElectionNamespace == "" {
+		leaderElectionNamespace = defaultLeaderElectionNamespace(saNamespace)
+	}
+
+	var objects []runtime.Object
+	for _, namespace := range namespaces {
+		objects = append(objects, namespacedRBAC(namespace, controllerName, subject, []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, ResourceNames: []string{namespace}, Verbs: []string{"get"}},
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"create", "delete", "get", "list", "patch", "update", "watch"}},
+			{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		})...)
+		if rollout {
+			objects = append(objects, namespacedRBAC(namespace, controllerName+"-rollout", subject, []rbacv1.PolicyRule{
+				{APIGroups: []string{"apps"}, Resources: []string{"daemonsets", "deployments", "statefulsets"}, Verbs: []string{"list", "patch"}},
+			})...)
+		}
+	}
+	if leaderElectionNamespace != "" {
+		objects = append(objects, namespacedRBAC(leaderElectionNamespace, controllerName+"-leader-election", subject, []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"create", "get", "update"}},
+		})...)
+	}
+
+	manifests := bytes.Buffer{}
+	for _, object := range objects {
+		raw, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		manifests.WriteString("---\n")
+		manifests.Write(raw)
+	}
+	return manifests.Bytes(), nil
+}
+
+// defaultLeaderElectionNamespace returns the namespace used by the manager
+// for the leader election when none is given: the namespace where the
+// controller runs, which is the namespace of its service account when the
+// manifests are generated outside the cluster.
+func defaultLeaderElectionNamespace(serviceAccountNamespace string) string {
+	if namespace, err := ioutil.ReadFile(inClusterNamespaceFile); err == nil {
+		return strings.TrimSpace(string(namespace))
+	}
+	return serviceAccountNamespace
+}
+
+// namespacedRBAC returns a Role with the given rules and its RoleBinding to
+// the given subject.
+func namespacedRBAC(namespace, name string, subject rbacv1.Subject, rules []rbacv1.PolicyRule) []runtime.Object {
+	meta := metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      name,
+		Labels: map[string]string{
+			"app.kubernetes.io/name":    controllerName,
+			"app.kubernetes.io/part-of": controllerName,
+		},
+	}
+
+	return []runtime.Object{
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: meta,
+			Rules:      rules,
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: meta,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   []rbacv1.Subject{subject},
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNamespacedRBACManifests(t *testing.T) {
+	manifests, err := NamespacedRBACManifests([]string{"team-a", "team-b"}, "team-a/sync-secrets-controller", false, true, "team-a")
+	require.NoError(t, err)
+
+	documents := strings.Split(strings.TrimPrefix(string(manifests), "---\n"), "---\n")
+	require.Len(t, documents, 6)
+
+	role := rbacv1.Role{}
+	require.NoError(t, yaml.Unmarshal([]byte(documents[2]), &role))
+	assert.Equal(t, "Role", role.Kind)
+	assert.Equal(t, "team-b", role.Namespace)
+	assert.Equal(t, []string{"team-b"}, role.Rules[0].ResourceNames)
+
+	binding := rbacv1.RoleBinding{}
+	require.NoError(t, yaml.Unmarshal([]byte(documents[5]), &binding))
+	assert.Equal(t, "RoleBinding", binding.Kind)
+	assert.Equal(t, "sync-secrets-controller-leader-election", binding.RoleRef.Name)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Namespace: "team-a", Name: "sync-secrets-controller"}}, binding.Subjects)
+
+	t.Run("WithDefaultLeaderElectionNamespace", func(t *testing.T) {
+		defer func(file string) { inClusterNamespaceFile = file }(inClusterNamespaceFile)
+		dir, err := ioutil.TempDir("", "rbac")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		inClusterNamespaceFile = filepath.Join(dir, "namespace")
+
+		// NOTE: outside the cluster, the controller namespace is the
+		//       namespace of its service account
+		manifests, err := NamespacedRBACManifests([]string{"team-a"}, "team-b/sync-secrets-controller", false, true, "")
+		require.NoError(t, err)
+		documents := strings.Split(strings.TrimPrefix(string(manifests), "---\n"), "---\n")
+		require.Len(t, documents, 4)
+		role := rbacv1.Role{}
+		require.NoError(t, yaml.Unmarshal([]byte(documents[2]), &role))
+		assert.Equal(t, "team-b", role.Namespace)
+		assert.Equal(t, "sync-secrets-controller-leader-election", role.Name)
+
+		require.NoError(t, ioutil.WriteFile(inClusterNamespaceFile, []byte("team-c\n"), 0600))
+		manifests, err = NamespacedRBACManifests([]string{"team-a"}, "team-b/sync-secrets-controller", false, true, "")
+		require.NoError(t, err)
+		documents = strings.Split(strings.TrimPrefix(string(manifests), "---\n"), "---\n")
+		require.NoError(t, yaml.Unmarshal([]byte(documents[2]), &role))
+		assert.Equal(t, "team-c", role.Namespace)
+	})
+	t.Run("WithoutLeaderElection", func(t *testing.T) {
+		manifests, err := NamespacedRBACManifests([]string{"team-a"}, "team-a/sync-secrets-controller", false, false, "")
+		require.NoError(t, err)
+		assert.Len(t, strings.Split(strings.TrimPrefix(string(manifests), "---\n"), "---\n"), 2)
+	})
+	t.Run("WithRollout", func(t *testing.T) {
+		manifests, err := NamespacedRBACManifests([]string{"team-a"}, "team-a/sync-secrets-controller", true, false, "")
+		require.NoError(t, err)
+		documents := strings.Split(strings.TrimPrefix(string(manifests), "---\n"), "---\n")
+		require.Len(t, documents, 4)
+		role := rbacv1.Role{}
+		require.NoError(t, yaml.Unmarshal([]byte(documents[2]), &role))
+		assert.Equal(t, "sync-secrets-controller-rollout", role.Name)
+		assert.Equal(t, []string{"apps"}, role.Rules[0].APIGroups)
+	})
+	t.Run("WithInvalidServiceAccount", func(t *testing.T) {
+		_, err := NamespacedRBACManifests([]string{"team-a"}, "sync-secrets-controller", false, false, "")
+		assert.EqualError(t, err, "invalid service account 'sync-secrets-controller': must be formatted as <namespace>/<name>")
+	})
+}
@@ -0,0 +1,678 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+const (
+	// RemoteClusterLabelKey is the label identifying the kubeconfig secrets
+	// which define the remote clusters, in the remote clusters namespace.
+	RemoteClusterLabelKey = "secret.sync.klst.pw/remote-cluster"
+	// RemoteNamespaceSelectorAnnotationKey is the annotation of a kubeconfig
+	// secret selecting the namespaces of its remote cluster where secrets
+	// are synchronized (all namespaces if missing).
+	RemoteNamespaceSelectorAnnotationKey = "secret.sync.klst.pw/remote-namespace-selector"
+	// RemoteClustersAnnotationKey is the annotation listing the remote
+	// clusters where a managed secret is also synchronized ('*' for all).
+	RemoteClustersAnnotationKey = "secret.sync.klst.pw/remote-clusters"
+	// RemoteOwnerAnnotationKey is the annotation keeping the UID of the
+	// managed secret of an owned secret written on a remote cluster; owner
+	// references cannot be used there, because the garbage collector of the
+	// remote cluster would remove the owned secrets of unknown owners.
+	RemoteOwnerAnnotationKey = "secret.sync.klst.pw/remote-owner"
+	// KubeconfigDataKey is the data key of a kubeconfig secret containing
+	// the kubeconfig of its remote cluster.
+	KubeconfigDataKey = "kubeconfig"
+)
+
+// remoteClusterProbePeriod is the period of the remote cluster health checks.
+const remoteClusterProbePeriod = 30 * time.Second
+
+// remote cluster states
+const (
+	remoteClusterUnknown int32 = iota
+	remoteClusterReady
+	remoteClusterFailed
+)
+
+type (
+	// remoteClusters keeps all remote clusters defined by the kubeconfig
+	// secrets of the remote clusters namespace.
+	remoteClusters struct {
+		newClient func(kubeconfig []byte) (client.Client, error)
+		clusters  map[string]*remoteCluster
+		// invalid keeps the resource version of the invalid kubeconfig
+		// secrets, in order to not load them again until they are updated
+		invalid map[string]string
+		// updated is notified when the kubeconfig secrets are updated; it
+		// is buffered in order to merge the pending notifications
+		updated chan struct{}
+
+		mx sync.Mutex
+	}
+
+	// remoteCluster is a remote cluster, with its own context: its client,
+	// its registry and its namespace selector.
+	remoteCluster struct {
+		ctx *Context
+		// secret is the kubeconfig secret (without its data) defining the
+		// remote cluster, used to emit events
+		secret corev1.Secret
+		state  int32
+
+		bootstrapped bool
+		mx           sync.Mutex
+	}
+)
+
+func newRemoteClusters(newClient func(kubeconfig []byte) (client.Client, error)) *remoteClusters {
+	return &remoteClusters{
+		newClient: newClient,
+		clusters:  map[string]*remoteCluster{},
+		invalid:   map[string]string{},
+		updated:   make(chan struct{}, 1),
+	}
+}
+
+// newRemoteClient creates a new client based on the given kubeconfig.
+func newRemoteClient(kubeconfig []byte) (client.Client, error) {
+	raw, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	if err := validateKubeconfig(raw); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	config, err := clientcmd.NewDefaultClientConfig(*raw, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	return client.New(config, client.Options{Scheme: scheme.Scheme})
+}
+
+// validateKubeconfig rejects the kubeconfigs using any field other than the
+// inline server, certificate authority, token and client certificate. The
+// other fields could make the controller run commands or read its own files
+// (like its service account token) and send them to any server.
+func validateKubeconfig(config *clientcmdapi.Config) error {
+	names := make([]string, 0, len(config.Clusters))
+	for name := range config.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cluster := config.Clusters[name]
+		forbidden := []struct {
+			field string
+			set   bool
+		}{
+			{"tls-server-name", cluster.TLSServerName != ""},
+			{"insecure-skip-tls-verify", cluster.InsecureSkipTLSVerify},
+			{"certificate-authority", cluster.CertificateAuthority != ""},
+			{"extensions", len(cluster.Extensions) > 0},
+		}
+		for _, f := range forbidden {
+			if f.set {
+				return fmt.Errorf("cluster '%s' uses the forbidden field '%s'", name, f.field)
+			}
+		}
+	}
+
+	names = make([]string, 0, len(config.AuthInfos))
+	for name := range config.AuthInfos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		user := config.AuthInfos[name]
+		forbidden := []struct {
+			field string
+			set   bool
+		}{
+			{"client-certificate", user.ClientCertificate != ""},
+			{"client-key", user.ClientKey != ""},
+			{"tokenFile", user.TokenFile != ""},
+			{"as", user.Impersonate != "" || len(user.ImpersonateGroups) > 0 || len(user.ImpersonateUserExtra) > 0},
+			{"username", user.Username != "" || user.Password != ""},
+			{"auth-provider", user.AuthProvider != nil},
+			{"exec", user.Exec != nil},
+			{"extensions", len(user.Extensions) > 0},
+		}
+		for _, f := range forbidden {
+			if f.set {
+				return fmt.Errorf("user '%s' uses the forbidden field '%s'", name, f.field)
+			}
+		}
+	}
+	return nil
+}
+
+// isRemoteClusterSecret returns true if the given secret defines a remote
+// cluster.
+func isRemoteClusterSecret(ctx *Context, secret corev1.Secret) bool {
+	return ctx.remoteClusters != nil &&
+		secret.Namespace == ctx.RemoteClustersNamespace &&
+		strings.ToLower(secret.Labels[RemoteClusterLabelKey]) == "true"
+}
+
+// isSelectedCluster returns true if the given managed secret must be
+// synchronized on the remote cluster with the given name.
+func isSelectedCluster(secret corev1.Secret, name string) bool {
+	for _, cluster := range strings.Split(secret.Annotations[RemoteClustersAnnotationKey], ",") {
+		if cluster = strings.TrimSpace(cluster); cluster == "*" || cluster == name {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh loads the remote clusters from their kubeconfig secrets; remote
+// clusters are created again when their kubeconfig secret is updated and
+// forgotten when it is removed.
+func (m *remoteClusters) refresh(ctx *Context) error {
+	secrets := &corev1.SecretList{}
+	err := ctx.client.List(ctx, secrets, client.InNamespace(ctx.RemoteClustersNamespace), client.MatchingLabels{RemoteClusterLabelKey: "true"})
+	if err != nil {
+		return ClientError{fmt.Errorf("failed to list remote clusters: %w", err)}
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	defined := map[string]bool{}
+	for _, secret := range secrets.Items {
+		defined[secret.Name] = true
+		if cluster, exists := m.clusters[secret.Name]; exists && cluster.secret.ResourceVersion == secret.ResourceVersion {
+			continue
+		}
+		if version, exists := m.invalid[secret.Name]; exists && version == secret.ResourceVersion {
+			continue
+		}
+
+		cluster, err := m.newCluster(ctx, secret)
+		if err != nil {
+			klog.Errorf("failed to load remote cluster %s: %s", secret.Name, err)
+			ctx.recorder.Eventf(&secret, corev1.EventTypeWarning, RemoteClusterFailedReason, "Failed to load remote cluster: %s", err)
+			remoteClusterUp.WithLabelValues(secret.Name).Set(0)
+			delete(m.clusters, secret.Name)
+			m.invalid[secret.Name] = secret.ResourceVersion
+			continue
+		}
+		klog.V(1).Infof("remote cluster %s loaded", secret.Name)
+		m.clusters[secret.Name] = cluster
+		delete(m.invalid, secret.Name)
+	}
+
+	for name := range m.clusters {
+		if !defined[name] {
+			klog.V(1).Infof("remote cluster %s removed", name)
+			delete(m.clusters, name)
+			remoteClusterUp.DeleteLabelValues(name)
+		}
+	}
+	for name := range m.invalid {
+		if !defined[name] {
+			delete(m.invalid, name)
+			remoteClusterUp.DeleteLabelValues(name)
+		}
+	}
+	return nil
+}
+
+// newCluster creates a new remote cluster from the given kubeconfig secret.
+func (m *remoteClusters) newCluster(ctx *Context, secret corev1.Secret) (*remoteCluster, error) {
+	selector := labels.Everything()
+	if value, exists := secret.Annotations[RemoteNamespaceSelectorAnnotationKey]; exists {
+		var err error
+		if selector, err = labels.Parse(value); err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", RemoteNamespaceSelectorAnnotationKey, err)
+		}
+	}
+
+	kubeconfig, exists := secret.Data[KubeconfigDataKey]
+	if !exists {
+		return nil, fmt.Errorf("no '%s' key found", KubeconfigDataKey)
+	}
+	c, err := m.newClient(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	c = remoteOwnerClient{c}
+
+	remote := *ctx
+	remote.cluster = secret.Name
+	remote.clusterNamespaceSelector = selector
+	remote.client = c
+	remote.namespaceReader = c
+	remote.workloadReader = c
+	remote.registry = registry.New()
+	remote.rollouts = newPendingRollouts()
+	remote.recorder = remoteClusterRecorder{EventRecorder: ctx.recorder, cluster: secret.Name, secrets: ctx.registry}
+	remote.remoteClusters = nil
+	remote.WatchNamespaces = nil
+	// NOTE: the source policy and the annotator authorization are checked
+	//       against the local cluster, where the managed secrets and the
+	//       annotators are
+	remote.SourceNamespaces, remote.SourceNamespaceSelector = nil, nil
+	remote.AuthorizeAnnotator = false
+
+	cluster := &remoteCluster{ctx: &remote, secret: secret}
+	cluster.secret.Data = nil
+	return cluster, nil
+}
+
+// remoteOwnerClient is a client for remote clusters, storing the owner
+// references of the owned secrets in an annotation when they are written
+// and restoring them when they are read.
+type remoteOwnerClient struct{ client.Client }
+
+func (c remoteOwnerClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	err := c.Client.Get(ctx, key, obj)
+	if secret, isSecret := obj.(*corev1.Secret); isSecret && err == nil {
+		restoreRemoteOwner(secret)
+	}
+	return err
+}
+
+func (c remoteOwnerClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	err := c.Client.List(ctx, list, opts...)
+	if secrets, isSecretList := list.(*corev1.SecretList); isSecretList && err == nil {
+		for i := range secrets.Items {
+			restoreRemoteOwner(&secrets.Items[i])
+		}
+	}
+	return err
+}
+
+func (c remoteOwnerClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if secret, isSecret := obj.(*corev1.Secret); isSecret {
+		storeRemoteOwner(secret)
+		defer restoreRemoteOwner(secret)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c remoteOwnerClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if secret, isSecret := obj.(*corev1.Secret); isSecret {
+		storeRemoteOwner(secret)
+		defer restoreRemoteOwner(secret)
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// storeRemoteOwner moves the owner reference of the given owned secret to
+// its remote owner annotation.
+func storeRemoteOwner(secret *corev1.Secret) {
+	if len(secret.OwnerReferences) == 0 {
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[RemoteOwnerAnnotationKey] = string(secret.OwnerReferences[0].UID)
+	secret.OwnerReferences = nil
+}
+
+// restoreRemoteOwner rebuilds the owner reference of the given owned secret
+// from its remote owner annotation.
+func restoreRemoteOwner(secret *corev1.Secret) {
+	uid, exists := secret.Annotations[RemoteOwnerAnnotationKey]
+	if !exists {
+		return
+	}
+	delete(secret.Annotations, RemoteOwnerAnnotationKey)
+	secret.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "v1", Kind: "Secret", Name: secret.Labels[OriginNameLabelsKey], UID: types.UID(uid)},
+	}
+}
+
+// notify signals that the kubeconfig secrets have been updated, without
+// waiting for the signal to be handled.
+func (m *remoteClusters) notify() {
+	select {
+	case m.updated <- struct{}{}:
+	default:
+	}
+}
+
+// list returns all remote clusters, sorted by name.
+func (m *remoteClusters) list() []*remoteCluster {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	clusters := make([]*remoteCluster, 0, len(m.clusters))
+	for _, cluster := range m.clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].ctx.cluster < clusters[j].ctx.cluster })
+	return clusters
+}
+
+// bootstrap bootstraps the registry of the remote cluster once, in order to
+// find the owned secrets written before a restart.
+func (c *remoteCluster) bootstrap() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if c.bootstrapped {
+		return nil
+	}
+	if err := bootstrapRegistry(c.ctx); err != nil {
+		return err
+	}
+	c.bootstrapped = true
+	return nil
+}
+
+// synchronize synchronizes the given managed secret on the remote cluster.
+func (c *remoteCluster) synchronize(secret corev1.Secret) (time.Duration, error) {
+	if err := c.bootstrap(); err != nil {
+		return 0, err
+	}
+	return SynchronizeSecret(c.ctx, secret)
+}
+
+// prune removes all owned secrets of the managed secret with the given UID
+// from the remote cluster; they are only reported in dry-run.
+func (c *remoteCluster) prune(uid types.UID, dryRun bool) error {
+	if err := c.bootstrap(); err != nil {
+		return err
+	}
+
+	ctx := c.ctx
+	if dryRun {
+		ctx = newDryRunContext(ctx)
+	}
+
+	for _, owned := range ctx.registry.OwnedSecretsWithUID(uid) {
+		// NOTE: the owned secret is fetched in order to audit its deletion
+		//       with its managed secret
+		secret := &corev1.Secret{}
+		if err := ctx.client.Get(ctx, owned, secret); errors.IsNotFound(err) {
+			_ = ctx.registry.UnregisterOwnedSecret(owned)
+			continue
+		} else if err != nil {
+			return ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, owned, err)}
+		}
+
+		klog.V(3).Infof("delete %T %s from remote cluster %s", secret, owned, ctx.cluster)
+		if err := deleteOwnedSecret(ctx, secret, PrunedReason); err != nil && !errors.IsNotFound(err) {
+			return ClientError{fmt.Errorf("failed to delete %T %s: %w", secret, owned, err)}
+		}
+		_ = ctx.registry.UnregisterOwnedSecret(owned)
+	}
+	_ = ctx.registry.UnregisterSecret(uid)
+	return nil
+}
+
+// orphan orphans all owned secrets of the given managed secret on the
+// remote cluster.
+func (c *remoteCluster) orphan(secret corev1.Secret) error {
+	if err := c.bootstrap(); err != nil {
+		return err
+	}
+	if err := orphanOwnedSecrets(c.ctx, secret); err != nil {
+		return err
+	}
+	_ = c.ctx.registry.UnregisterSecret(secret.UID)
+	return nil
+}
+
+// observe records the health of the remote cluster, based on the result of
+// the last request done on it. An event is emitted on its kubeconfig secret
+// when its health changes.
+func (c *remoteCluster) observe(ctx *Context, err error) {
+	state := remoteClusterReady
+	if err != nil {
+		state = remoteClusterFailed
+	}
+
+	if state == remoteClusterReady {
+		remoteClusterUp.WithLabelValues(c.ctx.cluster).Set(1)
+	} else {
+		remoteClusterUp.WithLabelValues(c.ctx.cluster).Set(0)
+	}
+	if atomic.SwapInt32(&c.state, state) == state {
+		return
+	}
+
+	if state == remoteClusterReady {
+		klog.V(1).Infof("remote cluster %s is ready", c.ctx.cluster)
+		ctx.recorder.Event(&c.secret, corev1.EventTypeNormal, RemoteClusterReadyReason, "Remote cluster is ready")
+		return
+	}
+	klog.Errorf("remote cluster %s failed: %s", c.ctx.cluster, err)
+	ctx.recorder.Eventf(&c.secret, corev1.EventTypeWarning, RemoteClusterFailedReason, "Remote cluster failed: %s", err)
+}
+
+// synchronizeRemoteClusters synchronizes the given managed secret on all
+// the remote clusters selected by its annotation, and removes its owned
+// secrets from the other remote clusters. It returns the delay before the
+// secret must be synchronized again (zero if not required).
+func synchronizeRemoteClusters(ctx *Context, secret corev1.Secret) (time.Duration, error) {
+	if ctx.remoteClusters == nil || ctx.cluster != "" {
+		return 0, nil
+	}
+	if ignored, err := isIgnoredSourceNamespace(ctx, secret.Namespace); err != nil || ignored {
+		return 0, err
+	}
+	if isPaused(ctx, secret) {
+		return 0, nil
+	}
+	if err := ctx.remoteClusters.refresh(ctx); err != nil {
+		return 0, err
+	}
+
+	// NOTE: the source policy is checked against the local cluster; the
+	//       owned secrets of denied secrets are removed from all clusters
+	quiet := *ctx
+	quiet.recorder = discardRecorder{}
+	_, denied := checkSourcePolicy(&quiet, secret).(PolicyError)
+	if !denied && ctx.AuthorizeAnnotator {
+		// NOTE: the owned secrets of secrets without annotator are kept
+		//       as is on remote clusters, until the secret is stamped
+		if secret.Annotations[AnnotatedByAnnotationKey] == "" {
+			return 0, nil
+		}
+		var err error
+		if denied, err = isRemoteSyncDenied(ctx, secret); err != nil {
+			return 0, err
+		}
+	}
+
+	var after time.Duration
+	var failures []string
+	for _, cluster := range ctx.remoteClusters.list() {
+		var delay time.Duration
+		var err error
+		if !denied && isSelectedCluster(secret, cluster.ctx.cluster) {
+			delay, err = cluster.synchronize(secret)
+		} else {
+			err = cluster.prune(secret.UID, isDryRun(ctx, secret))
+		}
+
+		switch err.(type) {
+		case nil, NoAnnotationError, AnnotationError:
+			// NOTE: annotation errors are already reported by the local
+			//       synchronization
+			after = minDelay(after, delay)
+			continue
+		case ClientError:
+			cluster.observe(ctx, err)
+		}
+		ctx.recorder.Eventf(&secret, corev1.EventTypeWarning, RemoteSyncFailedReason, "Failed to synchronize secret on remote cluster %s: %s", cluster.ctx.cluster, err)
+		failures = append(failures, fmt.Sprintf("%s: %s", cluster.ctx.cluster, err))
+	}
+
+	if len(failures) > 0 {
+		return after, ClientError{fmt.Errorf("failed to synchronize on remote clusters: %s", strings.Join(failures, "; "))}
+	}
+	return after, nil
+}
+
+// pruneRemoteClusters removes the owned secrets of the managed secret with
+// the given UID from all remote clusters.
+func pruneRemoteClusters(ctx *Context, uid types.UID) error {
+	if ctx.remoteClusters == nil || ctx.cluster != "" {
+		return nil
+	}
+	if err := ctx.remoteClusters.refresh(ctx); err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, cluster := range ctx.remoteClusters.list() {
+		err := cluster.prune(uid, false)
+		if _, isClientError := err.(ClientError); isClientError {
+			cluster.observe(ctx, err)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", cluster.ctx.cluster, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return ClientError{fmt.Errorf("failed to prune owned secrets on remote clusters: %s", strings.Join(failures, "; "))}
+	}
+	return nil
+}
+
+// pruneOrphans removes the owned secrets of the remote cluster whose
+// managed secret is not one of the given UIDs, like the owned secrets of
+// a managed secret removed while the controller was down. It returns how
+// many owned secrets were orphaned.
+func (c *remoteCluster) pruneOrphans(local *Context, uids map[types.UID]bool) (int, error) {
+	if err := c.bootstrap(); err != nil {
+		return 0, err
+	}
+
+	ctx := c.ctx
+	if ctx.DryRun {
+		ctx = newDryRunContext(ctx)
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := ctx.client.List(ctx, secrets); err != nil {
+		return 0, ClientError{fmt.Errorf("failed to list secrets: %w", err)}
+	}
+
+	count, orphaned := 0, map[types.UID]bool{}
+	for i, secret := range secrets.Items {
+		if _, isOwned := secret.Labels[OriginNameLabelsKey]; !isOwned || len(secret.OwnerReferences) == 0 || uids[secret.OwnerReferences[0].UID] {
+			continue
+		}
+		// NOTE: in namespace-scoped mode, managed secrets of unwatched
+		//       namespaces are unknown
+		origin := secret.Labels[OriginNamespaceLabelsKey]
+		if len(local.WatchNamespaces) > 0 && !funk.ContainsString(local.WatchNamespaces, origin) {
+			continue
+		}
+
+		name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+		klog.V(1).Infof("owned %T %s of remote cluster %s is orphaned, its managed secret no longer exists", secret, name, ctx.cluster)
+		orphaned[secret.OwnerReferences[0].UID] = true
+		count++
+
+		_ = ctx.registry.UnregisterOwnedSecret(name)
+		if err := deleteOwnedSecret(ctx, &secrets.Items[i], PrunedReason); err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("failed to delete orphaned %T %s from remote cluster %s: %s", secret, name, ctx.cluster, err)
+		}
+	}
+	for uid := range orphaned {
+		_ = ctx.registry.UnregisterSecret(uid)
+	}
+	return count, nil
+}
+
+// orphanRemoteClusters orphans the owned secrets of the given managed
+// secret on all remote clusters.
+func orphanRemoteClusters(ctx *Context, secret corev1.Secret) error {
+	if ctx.remoteClusters == nil || ctx.cluster != "" {
+		return nil
+	}
+	if err := ctx.remoteClusters.refresh(ctx); err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, cluster := range ctx.remoteClusters.list() {
+		err := cluster.orphan(secret)
+		if _, isClientError := err.(ClientError); isClientError {
+			cluster.observe(ctx, err)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", cluster.ctx.cluster, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return ClientError{fmt.Errorf("failed to orphan owned secrets on remote clusters: %s", strings.Join(failures, "; "))}
+	}
+	return nil
+}
+
+// probeRemoteClusters checks the health of all remote clusters.
+func probeRemoteClusters(ctx *Context) {
+	if err := ctx.remoteClusters.refresh(ctx); err != nil {
+		klog.Errorf("failed to refresh remote clusters: %s", err)
+		return
+	}
+
+	for _, cluster := range ctx.remoteClusters.list() {
+		cluster.observe(ctx, cluster.ctx.client.List(ctx, &corev1.NamespaceList{}, client.Limit(1)))
+	}
+}
+
+// runRemoteClusterUpdates gives all managed secrets to enqueue each time the
+// kubeconfig secrets are updated, in order to synchronize them on the new or
+// updated remote clusters, until stop is closed.
+func runRemoteClusterUpdates(ctx *Context, enqueue func(corev1.Secret), stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.remoteClusters.updated:
+			for _, name := range ctx.registry.Secrets() {
+				enqueue(corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}})
+			}
+		}
+	}
+}
+
+// runRemoteClusterProbes checks the health of all remote clusters every
+// period, until stop is closed.
+func runRemoteClusterProbes(ctx *Context, period time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		probeRemoteClusters(ctx)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
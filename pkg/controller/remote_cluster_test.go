@@ -0,0 +1,428 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/audit"
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+// unreachableClient is a client whose API server is unreachable.
+type unreachableClient struct{ client.Client }
+
+func (unreachableClient) Get(context.Context, client.ObjectKey, runtime.Object) error {
+	return fmt.Errorf("connection refused")
+}
+func (unreachableClient) List(context.Context, runtime.Object, ...client.ListOption) error {
+	return fmt.Errorf("connection refused")
+}
+
+// flakyDeleteClient is a client whose API server can refuse deletions.
+type flakyDeleteClient struct {
+	client.Client
+	unreachable bool
+}
+
+func (c *flakyDeleteClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if c.unreachable {
+		return fmt.Errorf("connection refused")
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newKubeconfigSecret(name, kubeconfig string, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "sync-system",
+			Name:        name,
+			Labels:      map[string]string{RemoteClusterLabelKey: "true"},
+			Annotations: annotations,
+		},
+		Data: map[string][]byte{KubeconfigDataKey: []byte(kubeconfig)},
+	}
+}
+
+// newRemoteClusterTestContext creates a context with two remote clusters,
+// 'east' (only namespaces labelled 'sync=remote') and 'west'.
+func newRemoteClusterTestContext(clusters map[string]client.Client) (*Context, *record.FakeRecorder) {
+	local := fake.NewFakeClientWithScheme(scheme.Scheme,
+		newNamespace("default", nil),
+		newNamespace("sync-system", nil),
+		newKubeconfigSecret("east", "east", map[string]string{RemoteNamespaceSelectorAnnotationKey: "sync=remote"}),
+		newKubeconfigSecret("west", "west", nil),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "secret",
+				UID:       "6f9f8a9e-0bfa-4ec1-a8d5-9c9b8f2f8c61",
+				Annotations: map[string]string{
+					NamespaceSelectorAnnotationKey: "sync=local",
+					RemoteClustersAnnotationKey:    "east",
+				},
+			},
+			Data: map[string][]byte{"username": []byte("my-app")},
+		},
+	)
+
+	recorder := record.NewFakeRecorder(100)
+	ctx := NewTestContext(context.TODO(), local, registry.New())
+	ctx.recorder = recorder
+	ctx.RemoteClustersNamespace = "sync-system"
+	ctx.remoteClusters = newRemoteClusters(func(kubeconfig []byte) (client.Client, error) {
+		if c, exists := clusters[string(kubeconfig)]; exists {
+			return c, nil
+		}
+		return nil, fmt.Errorf("invalid kubeconfig")
+	})
+	return ctx, recorder
+}
+
+func newRemoteCluster() client.Client {
+	return fake.NewFakeClientWithScheme(scheme.Scheme,
+		newNamespace("default", map[string]string{"sync": "remote"}),
+		newNamespace("team-a", map[string]string{"sync": "remote"}),
+		newNamespace("team-b", nil),
+	)
+}
+
+func reconcileSecret(t *testing.T, ctx *Context, name string) reconcile.Result {
+	namespace, name := strings.Split(name, "/")[0], strings.Split(name, "/")[1]
+	result, err := (&SecretReconciler{ctx}).Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}})
+	require.NoError(t, err)
+	return result
+}
+
+func assertSecret(t *testing.T, c client.Client, name string, exists bool) *corev1.Secret {
+	namespace, name := strings.Split(name, "/")[0], strings.Split(name, "/")[1]
+	secret := &corev1.Secret{}
+	err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if exists {
+		assert.NoError(t, err, "%s/%s must exist", namespace, name)
+	} else {
+		assert.True(t, errors.IsNotFound(err), "%s/%s must not exist", namespace, name)
+	}
+	return secret
+}
+
+func updateAnnotation(t *testing.T, c client.Client, name, key, value string) {
+	secret := assertSecret(t, c, name, true)
+	secret.Annotations[key] = value
+	require.NoError(t, c.Update(context.TODO(), secret))
+}
+
+func TestSynchronizeRemoteClusters(t *testing.T) {
+	east, west := newRemoteCluster(), newRemoteCluster()
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": west})
+
+	reconcileSecret(t, ctx, "default/secret")
+
+	t.Run("WithSelectedCluster", func(t *testing.T) {
+		secret := assertSecret(t, east, "team-a/secret", true)
+		assert.Equal(t, []byte("my-app"), secret.Data["username"])
+		assert.Equal(t, "default", secret.Labels[OriginNamespaceLabelsKey])
+		assert.Empty(t, secret.OwnerReferences)
+		assert.NotContains(t, secret.Annotations, RemoteClustersAnnotationKey)
+
+		raw := &corev1.Secret{}
+		require.NoError(t, east.Get(context.TODO(), types.NamespacedName{Namespace: "team-a", Name: "secret"}, raw))
+		assert.Equal(t, "6f9f8a9e-0bfa-4ec1-a8d5-9c9b8f2f8c61", raw.Annotations[RemoteOwnerAnnotationKey])
+
+		// NOTE: the namespace of the managed secret is also synchronized on
+		//       remote clusters
+		assertSecret(t, east, "default/secret", true)
+		assertSecret(t, east, "team-b/secret", false)
+	})
+	t.Run("WithUnselectedCluster", func(t *testing.T) {
+		assertSecret(t, west, "default/secret", false)
+		assertSecret(t, west, "team-a/secret", false)
+		assertSecret(t, west, "team-b/secret", false)
+	})
+
+	t.Run("WithUpdatedSelection", func(t *testing.T) {
+		updateAnnotation(t, ctx.client, "default/secret", RemoteClustersAnnotationKey, "west")
+		reconcileSecret(t, ctx, "default/secret")
+
+		assertSecret(t, east, "default/secret", false)
+		assertSecret(t, east, "team-a/secret", false)
+		assertSecret(t, west, "default/secret", true)
+		assertSecret(t, west, "team-a/secret", true)
+		assertSecret(t, west, "team-b/secret", true)
+	})
+
+	t.Run("AfterRestart", func(t *testing.T) {
+		// NOTE: owned secrets of remote clusters must be found again from
+		//       the remote clusters after a restart
+		ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": west})
+		reconcileSecret(t, ctx, "default/secret")
+
+		assertSecret(t, east, "team-a/secret", true)
+		assertSecret(t, west, "team-a/secret", false)
+	})
+}
+
+func TestSynchronizeRemoteClusters_WithDeletedSecret(t *testing.T) {
+	east := newRemoteCluster()
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+	auditor := &auditSink{}
+	ctx.auditor = audit.New([]byte("audit-key"), nil, auditor)
+	defer ctx.auditor.Close()
+
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "team-a/secret", true)
+
+	require.NoError(t, ctx.client.Delete(context.TODO(), assertSecret(t, ctx.client, "default/secret", true)))
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "default/secret", false)
+	assertSecret(t, east, "team-a/secret", false)
+
+	// NOTE: audit records of remote clusters identify their cluster and
+	//       the managed secret of the removed owned secrets
+	ctx.auditor.Flush()
+	var deleted []audit.Record
+	for _, record := range auditor.records {
+		assert.Equal(t, "east", record.Cluster)
+		if record.Operation == deleteOperation {
+			deleted = append(deleted, record)
+		}
+	}
+	require.Len(t, deleted, 2)
+	for _, record := range deleted {
+		assert.Equal(t, audit.Object{Namespace: "default", Name: "secret", UID: "6f9f8a9e-0bfa-4ec1-a8d5-9c9b8f2f8c61"}, record.Source)
+	}
+}
+
+func TestSynchronizeRemoteClusters_WithDeletedSecretAndUnreachableCluster(t *testing.T) {
+	east := &flakyDeleteClient{Client: newRemoteCluster()}
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+	name := types.NamespacedName{Namespace: "default", Name: "secret"}
+
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "team-a/secret", true)
+
+	require.NoError(t, ctx.client.Delete(context.TODO(), assertSecret(t, ctx.client, "default/secret", true)))
+	east.unreachable = true
+	_, err := (&SecretReconciler{ctx}).Reconcile(reconcile.Request{NamespacedName: name})
+	assert.EqualError(t, err, "failed to prune owned secrets on remote clusters: east: failed to delete *v1.Secret default/secret: connection refused")
+	assert.NotNil(t, ctx.registry.SecretWithName(name))
+
+	// NOTE: the managed secret is only forgotten once its owned secrets are
+	//       removed from all remote clusters
+	east.unreachable = false
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "default/secret", false)
+	assertSecret(t, east, "team-a/secret", false)
+	assert.Nil(t, ctx.registry.SecretWithName(name))
+}
+
+func TestResyncSecrets_WithRemoteOrphanedSecret(t *testing.T) {
+	east := newRemoteCluster()
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+
+	reconcileSecret(t, ctx, "default/secret")
+	require.NoError(t, resyncSecrets(ctx, func(corev1.Secret) {}))
+	assertSecret(t, east, "team-a/secret", true)
+
+	// NOTE: the managed secret is removed while the controller is down
+	ctx, _ = newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+	require.NoError(t, ctx.client.Delete(context.TODO(), assertSecret(t, ctx.client, "default/secret", true)))
+
+	require.NoError(t, resyncSecrets(ctx, func(corev1.Secret) {}))
+	assertSecret(t, east, "default/secret", false)
+	assertSecret(t, east, "team-a/secret", false)
+}
+
+func TestReconcileRemoteClusters(t *testing.T) {
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": newRemoteCluster(), "west": newRemoteCluster()})
+	reconcileSecret(t, ctx, "default/secret")
+
+	enqueued := make(chan corev1.Secret, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go runRemoteClusterUpdates(ctx, func(secret corev1.Secret) { enqueued <- secret }, stop)
+
+	// NOTE: managed secrets are enqueued instead of being reconciled with
+	//       the kubeconfig secret
+	updateAnnotation(t, ctx.client, "sync-system/east", RemoteNamespaceSelectorAnnotationKey, "sync in (local,remote)")
+	assert.Equal(t, reconcile.Result{}, reconcileSecret(t, ctx, "sync-system/east"))
+	select {
+	case secret := <-enqueued:
+		assert.Equal(t, "default", secret.Namespace)
+		assert.Equal(t, "secret", secret.Name)
+	case <-time.After(time.Second):
+		t.Fatal("managed secrets must be enqueued when a remote cluster is updated")
+	}
+}
+
+func TestSynchronizeRemoteClusters_WithOrphanedSecret(t *testing.T) {
+	east := newRemoteCluster()
+	ctx, _ := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+
+	updateAnnotation(t, ctx.client, "default/secret", DeletionPolicyAnnotationKey, OrphanDeletionPolicy)
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "team-a/secret", true)
+
+	// NOTE: owned secrets of remote clusters must also be orphaned, even
+	//       if they are not known yet (after a restart)
+	secret := assertSecret(t, ctx.client, "default/secret", true)
+	require.Contains(t, secret.Finalizers, OrphanFinalizer)
+	now := metav1.Now()
+	secret.DeletionTimestamp = &now
+	require.NoError(t, ctx.client.Update(context.TODO(), secret))
+	ctx.registry = registry.New()
+	ctx.remoteClusters = newRemoteClusters(ctx.remoteClusters.newClient)
+	reconcileSecret(t, ctx, "default/secret")
+
+	orphan := assertSecret(t, east, "team-a/secret", true)
+	assert.Empty(t, orphan.OwnerReferences)
+	assert.NotContains(t, orphan.Annotations, RemoteOwnerAnnotationKey)
+	assert.NotContains(t, orphan.Labels, OriginNameLabelsKey)
+	assert.Empty(t, assertSecret(t, ctx.client, "default/secret", true).Finalizers)
+}
+
+func TestSynchronizeRemoteClusters_WithAuthorizeAnnotator(t *testing.T) {
+	east := newRemoteCluster()
+	ctx, recorder := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+	authorizer := namespaceAuthorizer{"alice": {"team-a"}}
+	ctx.AuthorizeAnnotator, ctx.authorizer = true, authorizer
+	updateAnnotation(t, ctx.client, "default/secret", AnnotatedByAnnotationKey, "alice")
+
+	// NOTE: the annotator must be allowed to create secrets in the remote
+	//       clusters namespace to synchronize secrets on remote clusters
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "team-a/secret", false)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	assert.Contains(t, events, "Warning AnnotatorUnauthorized alice is not allowed to create secrets in namespace(s) sync-system")
+
+	authorizer["alice"] = append(authorizer["alice"], "sync-system")
+	reconcileSecret(t, ctx, "default/secret")
+	assertSecret(t, east, "team-a/secret", true)
+}
+
+func TestSynchronizeRemoteClusters_WithUnreachableCluster(t *testing.T) {
+	ctx, recorder := newRemoteClusterTestContext(map[string]client.Client{"east": unreachableClient{}, "west": newRemoteCluster()})
+
+	result := reconcileSecret(t, ctx, "default/secret")
+	assert.Equal(t, requeueAfter, result.RequeueAfter)
+	assert.Equal(t, float64(0), testutil.ToFloat64(remoteClusterUp.WithLabelValues("east")))
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	assert.Contains(t, events, "Warning RemoteClusterFailed Remote cluster failed: failed to list secrets: connection refused")
+	assert.Contains(t, events, "Warning RemoteSyncFailed Failed to synchronize secret on remote cluster east: failed to list secrets: connection refused")
+}
+
+func TestProbeRemoteClusters(t *testing.T) {
+	east := &unreachableClient{}
+	ctx, recorder := newRemoteClusterTestContext(map[string]client.Client{"east": east, "west": newRemoteCluster()})
+
+	probeRemoteClusters(ctx)
+	assert.Equal(t, float64(0), testutil.ToFloat64(remoteClusterUp.WithLabelValues("east")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(remoteClusterUp.WithLabelValues("west")))
+	assert.Equal(t, "Warning RemoteClusterFailed Remote cluster failed: connection refused", <-recorder.Events)
+	assert.Equal(t, "Normal RemoteClusterReady Remote cluster is ready", <-recorder.Events)
+
+	// NOTE: events are only emitted when the health of a remote cluster
+	//       changes
+	probeRemoteClusters(ctx)
+	assert.Empty(t, recorder.Events)
+
+	// NOTE: remote clusters are forgotten once their kubeconfig secret is
+	//       removed
+	require.NoError(t, ctx.client.Delete(context.TODO(), newKubeconfigSecret("west", "west", nil)))
+	probeRemoteClusters(ctx)
+	assert.Len(t, ctx.remoteClusters.list(), 1)
+}
+
+func TestRemoteClusters_WithInvalidKubeconfig(t *testing.T) {
+	ctx, recorder := newRemoteClusterTestContext(map[string]client.Client{"west": newRemoteCluster()})
+
+	require.NoError(t, ctx.remoteClusters.refresh(ctx))
+	assert.Len(t, ctx.remoteClusters.list(), 1)
+	assert.Equal(t, float64(0), testutil.ToFloat64(remoteClusterUp.WithLabelValues("east")))
+	assert.Equal(t, "Warning RemoteClusterFailed Failed to load remote cluster: invalid kubeconfig", <-recorder.Events)
+
+	// NOTE: invalid kubeconfig secrets are not loaded again until they are
+	//       updated
+	require.NoError(t, ctx.remoteClusters.refresh(ctx))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestNewRemoteClient_WithUnsafeKubeconfig(t *testing.T) {
+	kubeconfig := func(cluster, user string) string {
+		return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote.example.com
+    certificate-authority-data: Q0E=
+%s
+users:
+- name: remote
+  user:
+    token: secret-token
+%s
+contexts:
+- name: remote
+  context: {cluster: remote, user: remote}
+current-context: remote
+`, cluster, user)
+	}
+
+	tests := []struct {
+		name       string
+		kubeconfig string
+		err        string
+	}{
+		{"WithExec", kubeconfig("", "    exec: {apiVersion: client.authentication.k8s.io/v1alpha1, command: sh}"), "user 'remote' uses the forbidden field 'exec'"},
+		{"WithAuthProvider", kubeconfig("", "    auth-provider: {name: gcp}"), "user 'remote' uses the forbidden field 'auth-provider'"},
+		{"WithTokenFile", kubeconfig("", "    tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token"), "user 'remote' uses the forbidden field 'tokenFile'"},
+		{"WithClientCertificate", kubeconfig("", "    client-certificate: /etc/tls/tls.crt"), "user 'remote' uses the forbidden field 'client-certificate'"},
+		{"WithClientKey", kubeconfig("", "    client-key: /etc/tls/tls.key"), "user 'remote' uses the forbidden field 'client-key'"},
+		{"WithBasicAuth", kubeconfig("", "    username: admin\n    password: admin"), "user 'remote' uses the forbidden field 'username'"},
+		{"WithImpersonation", kubeconfig("", "    as: system:admin"), "user 'remote' uses the forbidden field 'as'"},
+		{"WithCertificateAuthority", kubeconfig("    certificate-authority: /etc/tls/ca.crt", ""), "cluster 'remote' uses the forbidden field 'certificate-authority'"},
+		{"WithInsecureSkipTLSVerify", kubeconfig("    insecure-skip-tls-verify: true", ""), "cluster 'remote' uses the forbidden field 'insecure-skip-tls-verify'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRemoteClient([]byte(tt.kubeconfig))
+			assert.EqualError(t, err, "invalid kubeconfig: "+tt.err)
+		})
+	}
+
+	t.Run("WithInlineCredentials", func(t *testing.T) {
+		config, err := clientcmd.Load([]byte(kubeconfig("", "    client-certificate-data: Q0VSVA==\n    client-key-data: S0VZ")))
+		require.NoError(t, err)
+		assert.NoError(t, validateKubeconfig(config))
+	})
+}
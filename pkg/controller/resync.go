@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// drift types, used as metric label
+const (
+	missingDrift  = "missing"
+	staleDrift    = "stale"
+	orphanedDrift = "orphaned"
+)
+
+// resyncSecrets re-evaluates all managed secrets against the live cluster,
+// in order to detect the owned secrets which have drifted:
+//   - missing owned secrets, in a namespace selected by their managed secret
+//   - stale owned secrets, whose content differs from their managed secret
+//   - orphaned owned secrets, labelled with a managed secret which no longer
+//     selects them or which no longer exists (also on remote clusters)
+//
+// Managed secrets with drifted owned secrets are given to enqueue in order
+// to be synchronized again; orphaned owned secrets without managed secret
+// are removed.
+func resyncSecrets(ctx *Context, enqueue func(corev1.Secret)) error {
+	secrets := &corev1.SecretList{}
+	if err := ctx.client.List(ctx, secrets); err != nil {
+		return ClientError{fmt.Errorf("failed to list secrets: %w", err)}
+	}
+
+	live := map[types.NamespacedName]*corev1.Secret{}
+	uids := map[types.UID]bool{}
+	owned := map[types.UID][]*corev1.Secret{}
+	for i, secret := range secrets.Items {
+		live[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] = &secrets.Items[i]
+		uids[secret.UID] = true
+		if _, isOwned := secret.Labels[OriginNameLabelsKey]; isOwned && len(secret.OwnerReferences) > 0 {
+			owned[secret.OwnerReferences[0].UID] = append(owned[secret.OwnerReferences[0].UID], &secrets.Items[i])
+		}
+	}
+
+	// NOTE: the drift detection must not emit any event; events are emitted
+	//       when the drifted secrets are synchronized again
+	quiet := *ctx
+	quiet.recorder = discardRecorder{}
+
+	drifts := map[string]int{}
+	for _, secret := range secrets.Items {
+		if len(secret.OwnerReferences) > 0 || (!hasSyncAnnotations(secret) && len(owned[secret.UID]) == 0) {
+			continue
+		}
+		if ignored, _ := isIgnoredSourceNamespace(&quiet, secret.Namespace); ignored || isPaused(&quiet, secret) {
+			continue
+		}
+		name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+
+		var namespaces []string
+		if err := checkSourcePolicy(&quiet, secret); err == nil {
+			namespaces, _ = listNamespacesFromAnnotations(&quiet, secret)
+		} else if _, denied := err.(PolicyError); !denied {
+			klog.Errorf("failed to resync %T %s: %s", secret, name, err)
+			continue
+		}
+		template := newOwnedSecretTemplate(&quiet, &secret)
+		templates := ownedSecretTemplates(&quiet, secret, template)
+		names := templateNames(templates)
+
+		// NOTE: owned secrets not reached yet by a staged rollout keep their
+		//       previous content on purpose; they have not drifted
+		var plan *rolloutPlan
+		if strategy, _ := parseRolloutStrategy(secret); strategy != nil {
+			plan = &rolloutPlan{previous: previousTemplates(owned[secret.UID], template)}
+		}
+
+		drifted := 0
+		for _, namespace := range namespaces {
+			for _, template := range templates {
+				target, exists := live[types.NamespacedName{Namespace: namespace, Name: template.Name}]
+				switch {
+				case !exists:
+					klog.V(1).Infof("owned %T %s/%s of %s is missing", secret, namespace, template.Name, name)
+					drifts[missingDrift]++
+					drifted++
+				case len(target.OwnerReferences) > 0 && target.OwnerReferences[0].UID == secret.UID && !isSynchronized(target, template) && !plan.isPending(target):
+					klog.V(1).Infof("owned %T %s/%s of %s is stale", secret, namespace, template.Name, name)
+					drifts[staleDrift]++
+					drifted++
+				}
+			}
+		}
+		// NOTE: the registry must not be modified for secrets in dry-run
+		registryCtx := ctx
+		if isDryRun(ctx, secret) {
+			registryCtx = newDryRunContext(&quiet)
+		}
+		for _, target := range owned[secret.UID] {
+			if funk.ContainsString(namespaces, target.Namespace) && funk.ContainsString(names, target.Name) {
+				continue
+			}
+
+			// NOTE: orphaned owned secrets are registered in order to be
+			//       pruned during the synchronization
+			ownedName := types.NamespacedName{Namespace: target.Namespace, Name: target.Name}
+			klog.V(1).Infof("owned %T %s of %s is orphaned", secret, ownedName, name)
+			_ = registryCtx.registry.RegisterSecret(name, secret.UID)
+			_ = registryCtx.registry.RegisterOwnedSecret(secret.UID, ownedName)
+			drifts[orphanedDrift]++
+			drifted++
+		}
+
+		if drifted > 0 {
+			enqueue(secret)
+		}
+	}
+
+	pruneCtx := ctx
+	if ctx.DryRun {
+		pruneCtx = newDryRunContext(ctx)
+	}
+	for uid, targets := range owned {
+		if uids[uid] {
+			continue
+		}
+
+		for _, target := range targets {
+			// NOTE: in namespace-scoped mode, managed secrets of unwatched
+			//       namespaces are unknown
+			origin := target.Labels[OriginNamespaceLabelsKey]
+			if len(ctx.WatchNamespaces) > 0 && !funk.ContainsString(ctx.WatchNamespaces, origin) {
+				continue
+			}
+
+			ownedName := types.NamespacedName{Namespace: target.Namespace, Name: target.Name}
+			klog.V(1).Infof("owned %T %s is orphaned, its managed secret no longer exists", target, ownedName)
+			drifts[orphanedDrift]++
+
+			_ = pruneCtx.registry.UnregisterOwnedSecret(ownedName)
+			if err := deleteOwnedSecret(pruneCtx, target, PrunedReason); err != nil && !errors.IsNotFound(err) {
+				klog.Errorf("failed to delete orphaned %T %s: %s", target, ownedName, err)
+			}
+		}
+	}
+
+	// NOTE: owned secrets of remote clusters are orphaned when their managed
+	//       secret is removed while the controller is down
+	if ctx.remoteClusters != nil && ctx.cluster == "" {
+		if err := ctx.remoteClusters.refresh(ctx); err != nil {
+			klog.Errorf("failed to resync remote clusters: %s", err)
+		} else {
+			for _, cluster := range ctx.remoteClusters.list() {
+				count, err := cluster.pruneOrphans(ctx, uids)
+				if _, isClientError := err.(ClientError); isClientError {
+					cluster.observe(ctx, err)
+				}
+				if err != nil {
+					klog.Errorf("failed to resync remote cluster %s: %s", cluster.ctx.cluster, err)
+				}
+				drifts[orphanedDrift] += count
+			}
+		}
+	}
+
+	for drift, count := range drifts {
+		driftedOwnedSecretsTotal.WithLabelValues(drift).Add(float64(count))
+	}
+	klog.V(1).Infof("resync completed: %d missing, %d stale and %d orphaned owned secrets", drifts[missingDrift], drifts[staleDrift], drifts[orphanedDrift])
+	return nil
+}
+
+// runResync resyncs all managed secrets every period, until stop is closed.
+func runResync(ctx *Context, period time.Duration, enqueue func(corev1.Secret), stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if err := resyncSecrets(ctx, enqueue); err != nil {
+				klog.Errorf("failed to resync secrets: %s", err)
+			}
+			lastResyncTimestamp.SetToCurrentTime()
+			resyncDuration.Observe(time.Since(start).Seconds())
+		}
+	}
+}
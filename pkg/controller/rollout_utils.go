@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RolloutOnChangeAnnotationKey is the annotation used to restart the
+	// workloads which use an owned secret when its content changes.
+	RolloutOnChangeAnnotationKey = "secret.sync.klst.pw/rollout-on-change"
+	// RestartedWithAnnotationKeyPrefix is the prefix of the annotations set
+	// on the pod templates of the restarted workloads, one per owned secret
+	// (suffixed by its name), containing the source hash of this secret.
+	RestartedWithAnnotationKeyPrefix = "secret.sync.klst.pw/restarted-with."
+)
+
+// maxAnnotationNameLength is the maximum length of the name of an annotation
+// key, without its prefix.
+const maxAnnotationNameLength = 63
+
+// workload is a Deployment, a StatefulSet or a DaemonSet, which can be
+// restarted by updating its pod template.
+type workload struct {
+	kind     string
+	object   runtime.Object
+	meta     metav1.Object
+	template *corev1.PodTemplateSpec
+}
+
+// pendingRollouts keeps the owned secrets whose workloads have not all been
+// restarted yet, with the source hash they must be restarted with.
+type pendingRollouts struct {
+	hashes map[types.NamespacedName]string
+	mx     sync.Mutex
+}
+
+func newPendingRollouts() *pendingRollouts {
+	return &pendingRollouts{hashes: map[types.NamespacedName]string{}}
+}
+
+// isPending returns true if the workloads using the given owned secret must
+// be restarted with the given source hash.
+func (p *pendingRollouts) isPending(name types.NamespacedName, hash string) bool {
+	if p == nil {
+		return false
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.hashes[name] == hash
+}
+
+// start marks the rollout of the given owned secret as pending, until all
+// its workloads have been restarted with the given source hash.
+func (p *pendingRollouts) start(name types.NamespacedName, hash string) {
+	if p == nil {
+		return
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.hashes[name] = hash
+}
+
+// complete marks the rollout of the given owned secret as completed.
+func (p *pendingRollouts) complete(name types.NamespacedName, hash string) {
+	if p == nil {
+		return
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.hashes[name] == hash {
+		delete(p.hashes, name)
+	}
+}
+
+// restartedWithAnnotationKey returns the annotation set on the pod templates
+// of the workloads restarted with the owned secret with the given name; the
+// names too long to fit in an annotation key are shortened with their hash.
+func restartedWithAnnotationKey(name string) string {
+	prefix := strings.SplitN(RestartedWithAnnotationKeyPrefix, "/", 2)
+	key := prefix[1] + name
+	if len(key) > maxAnnotationNameLength {
+		sum := sha256.Sum256([]byte(name))
+		key = fmt.Sprintf("%s-%x", key[:maxAnnotationNameLength-9], sum[:4])
+	}
+	return prefix[0] + "/" + key
+}
+
+// isRolloutOnChange returns true if the workloads using the owned secrets of
+// the given managed secret must be restarted when their content changes.
+func isRolloutOnChange(secret corev1.Secret) bool {
+	rollout, _ := strconv.ParseBool(secret.Annotations[RolloutOnChangeAnnotationKey])
+	return rollout
+}
+
+// listWorkloads lists all Deployments, StatefulSets and DaemonSets of the
+// given namespace, directly from the API server.
+func listWorkloads(ctx *Context, namespace string) ([]workload, error) {
+	var workloads []workload
+
+	deployments := &appsv1.DeploymentList{}
+	if err := ctx.workloadReader.List(ctx, deployments, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		item := &deployments.Items[i]
+		workloads = append(workloads, workload{"Deployment", item, item, &item.Spec.Template})
+	}
+
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := ctx.workloadReader.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		item := &statefulSets.Items[i]
+		workloads = append(workloads, workload{"StatefulSet", item, item, &item.Spec.Template})
+	}
+
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := ctx.workloadReader.List(ctx, daemonSets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		item := &daemonSets.Items[i]
+		workloads = append(workloads, workload{"DaemonSet", item, item, &item.Spec.Template})
+	}
+	return workloads, nil
+}
+
+// usesSecret returns true if the given pod template references the secret
+// with the given name, through envFrom, env.valueFrom or volumes.
+func usesSecret(template corev1.PodSpec, name string) bool {
+	containers := append(append([]corev1.Container{}, template.InitContainers...), template.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+
+	for _, volume := range template.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil && source.Secret.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rolloutWorkloads restarts the workloads using the given owned secret, by
+// annotating their pod template with its source hash (one annotation per
+// owned secret). Workloads are only restarted if its content has changed
+// or if a previous rollout has failed; they are never restarted twice for
+// the same hash, so the rollout is retried until it succeeds.
+func rolloutWorkloads(ctx *Context, owner corev1.Secret, secret *corev1.Secret, changed bool) error {
+	ownedName := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	hash := secret.Annotations[SourceHashAnnotationKey]
+	// NOTE: workloads are not cached; they are only listed when they must
+	//       be restarted
+	if !changed && !ctx.rollouts.isPending(ownedName, hash) {
+		return nil
+	}
+	if !ctx.simulation {
+		ctx.rollouts.start(ownedName, hash)
+	}
+
+	workloads, err := listWorkloads(ctx, secret.Namespace)
+	if err != nil {
+		klog.Errorf("failed to list workloads of namespace %s: %s", secret.Namespace, err)
+		ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, RolloutFailedReason, "Failed to list workloads using owned secret %s/%s: %s", secret.Namespace, secret.Name, err)
+		return ClientError{fmt.Errorf("failed to list workloads of namespace %s: %w", secret.Namespace, err)}
+	}
+
+	key := restartedWithAnnotationKey(secret.Name)
+	var failures []string
+	for _, workload := range workloads {
+		if !usesSecret(workload.template.Spec, secret.Name) || workload.template.Annotations[key] == hash {
+			continue
+		}
+		name := fmt.Sprintf("%s/%s", workload.meta.GetNamespace(), workload.meta.GetName())
+		if ctx.simulation {
+			klog.V(0).Infof("[dry-run] would restart %s %s", workload.kind, name)
+			ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, RolloutTriggeredReason, "Would restart %s %s", workload.kind, name)
+			continue
+		}
+
+		original := workload.object.DeepCopyObject()
+		if workload.template.Annotations == nil {
+			workload.template.Annotations = map[string]string{}
+		}
+		workload.template.Annotations[key] = hash
+
+		klog.V(1).Infof("restart %s %s using owned %T %s/%s", workload.kind, name, secret, secret.Namespace, secret.Name)
+		if err := ctx.client.Patch(ctx, workload.object, client.MergeFrom(original)); err != nil {
+			klog.Errorf("failed to restart %s %s: %s", workload.kind, name, err)
+			ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, RolloutFailedReason, "Failed to restart %s %s: %s", workload.kind, name, err)
+			failures = append(failures, fmt.Sprintf("%s %s: %s", workload.kind, name, err))
+			continue
+		}
+		ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, RolloutTriggeredReason, "%s %s restarted", workload.kind, name)
+	}
+
+	if len(failures) > 0 {
+		return ClientError{fmt.Errorf("failed to restart workloads: %s", strings.Join(failures, "; "))}
+	}
+	if !ctx.simulation {
+		ctx.rollouts.complete(ownedName, hash)
+	}
+	return nil
+}
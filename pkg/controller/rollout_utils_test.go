@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/xunleii/sync-secrets-controller/pkg/registry"
+)
+
+// flakyPatchClient is a client whose patches fail while failures is
+// positive; it counts all patches and lists.
+type flakyPatchClient struct {
+	client.Client
+	failures int
+	patches  int
+	lists    int
+}
+
+func (c *flakyPatchClient) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	c.lists++
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *flakyPatchClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patches++
+	if c.failures > 0 {
+		c.failures--
+		return fmt.Errorf("connection refused")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func newDeployment(name string, annotations map[string]string, secrets ...string) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name}}
+	deployment.Spec.Template.Annotations = annotations
+	container := corev1.Container{Name: "app"}
+	for _, secret := range secrets {
+		container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret}},
+		})
+	}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{container}
+	return deployment
+}
+
+func newOwnedSecret(name, hash string) *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "team-a",
+		Name:        name,
+		Annotations: map[string]string{SourceHashAnnotationKey: hash},
+	}}
+}
+
+func restartedWith(t *testing.T, c client.Client, name, secret string) string {
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Namespace: "team-a", Name: name}, deployment))
+	return deployment.Spec.Template.Annotations[restartedWithAnnotationKey(secret)]
+}
+
+func TestRestartedWithAnnotationKey(t *testing.T) {
+	assert.Equal(t, "secret.sync.klst.pw/restarted-with.secret", restartedWithAnnotationKey("secret"))
+
+	key := restartedWithAnnotationKey(strings.Repeat("a", 100))
+	assert.Len(t, strings.SplitN(key, "/", 2)[1], maxAnnotationNameLength)
+	assert.NotEqual(t, key, restartedWithAnnotationKey(strings.Repeat("a", 101)))
+}
+
+func TestRolloutWorkloads(t *testing.T) {
+	c := &flakyPatchClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		newDeployment("never-restarted", nil, "secret"),
+		newDeployment("restarted", map[string]string{restartedWithAnnotationKey("secret"): "previous"}, "secret"),
+	)}
+	ctx := NewTestContext(context.TODO(), c, registry.New())
+	owner := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"}}
+	secret := newOwnedSecret("secret", "current")
+
+	t.Run("WithoutChange", func(t *testing.T) {
+		// NOTE: workloads are neither listed nor restarted when the content
+		//       has not changed
+		require.NoError(t, rolloutWorkloads(ctx, owner, secret, false))
+		assert.Equal(t, 0, c.lists)
+		assert.Equal(t, "", restartedWith(t, c, "never-restarted", "secret"))
+		assert.Equal(t, "previous", restartedWith(t, c, "restarted", "secret"))
+	})
+
+	t.Run("WithFailedRollout", func(t *testing.T) {
+		secret.Annotations[SourceHashAnnotationKey] = "next"
+		c.failures = 2
+		assert.Error(t, rolloutWorkloads(ctx, owner, secret, true))
+
+		// NOTE: the failed rollout is retried, even if the content of the
+		//       owned secret no longer changes
+		require.NoError(t, rolloutWorkloads(ctx, owner, secret, false))
+		assert.Equal(t, "next", restartedWith(t, c, "never-restarted", "secret"))
+		assert.Equal(t, "next", restartedWith(t, c, "restarted", "secret"))
+	})
+
+	t.Run("WithCompletedRollout", func(t *testing.T) {
+		c.patches, c.lists = 0, 0
+		require.NoError(t, rolloutWorkloads(ctx, owner, secret, true))
+		assert.Equal(t, 0, c.patches)
+
+		require.NoError(t, rolloutWorkloads(ctx, owner, secret, false))
+		assert.Equal(t, 3, c.lists)
+	})
+}
+
+func TestRolloutWorkloads_WithSeveralSecrets(t *testing.T) {
+	c := &flakyPatchClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		newDeployment("app", nil, "database", "api"),
+	)}
+	ctx := NewTestContext(context.TODO(), c, registry.New())
+	owner := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"}}
+	database, api := newOwnedSecret("database", "database-hash"), newOwnedSecret("api", "api-hash")
+
+	require.NoError(t, rolloutWorkloads(ctx, owner, database, true))
+	require.NoError(t, rolloutWorkloads(ctx, owner, api, true))
+	assert.Equal(t, 2, c.patches)
+	assert.Equal(t, "database-hash", restartedWith(t, c, "app", "database"))
+	assert.Equal(t, "api-hash", restartedWith(t, c, "app", "api"))
+
+	// NOTE: each owned secret keeps its own hash, so the workload is not
+	//       restarted again by the other owned secret
+	c.patches = 0
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rolloutWorkloads(ctx, owner, database, true))
+		require.NoError(t, rolloutWorkloads(ctx, owner, api, true))
+	}
+	assert.Equal(t, 0, c.patches)
+}
@@ -2,11 +2,11 @@ package controller
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/thoas/go-funk"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -23,11 +23,24 @@ func (r *SecretReconciler) Reconcile(req reconcile.Request) (reconcile.Result, e
 		klog.Errorf("failed to fetch %T %s: %s", secret, req.NamespacedName, err)
 		klog.V(5).Infof("this error occurs when the secret is deleted")
 
+		if r.remoteClusters != nil && req.Namespace == r.RemoteClustersNamespace {
+			if res, err := r.reconcileRemoteClusters(); err != nil {
+				return res, err
+			}
+		}
+
 		secret := r.registry.SecretWithName(req.NamespacedName)
 		if secret == nil {
 			return reconcile.Result{}, nil
 		}
 
+		// NOTE: the managed secret is kept in the registry until its owned
+		//       secrets are removed from all remote clusters, in order to
+		//       retry on failure
+		if err := pruneRemoteClusters(r.Context, secret.UID); err != nil {
+			klog.Errorf("failed to prune %T %s from remote clusters: %s... retry after %s", secret, req.NamespacedName, err, requeueAfter)
+			return reconcile.Result{RequeueAfter: requeueAfter}, err
+		}
 		if err := r.registry.UnregisterSecret(secret.UID); err != nil {
 			klog.Errorf("failed to remove %T %s from registry: %s", secret, req, err)
 		}
@@ -37,123 +50,329 @@ func (r *SecretReconciler) Reconcile(req reconcile.Request) (reconcile.Result, e
 		return reconcile.Result{RequeueAfter: requeueAfter}, err
 	}
 
+	if isRemoteClusterSecret(r.Context, secret) {
+		return r.reconcileRemoteClusters()
+	}
+
 	if len(secret.OwnerReferences) > 0 {
 		klog.V(5).Infof("ignore %T %s: secret already owned by someone", secret, req)
 		return reconcile.Result{}, nil
 	}
 
-	err = SynchronizeSecret(r.Context, secret)
-	if err == nil {
+	if secret.DeletionTimestamp != nil {
+		klog.V(3).Infof("%T %s is being deleted", secret, req)
+		if err := finalizeSecret(r.Context, secret); err != nil {
+			klog.Errorf("failed to finalize %T %s: %s... retry after %s", secret, req, err, requeueAfter)
+			return reconcile.Result{RequeueAfter: requeueAfter}, err
+		}
 		return reconcile.Result{}, nil
 	}
+
+	// NOTE: the managed secret itself (finalizers and synchronization
+	//       status) is never written in dry-run
+	dryRun := isDryRun(r.Context, secret)
+	if !dryRun {
+		if err := ensureOrphanFinalizer(r.Context, &secret); err != nil {
+			klog.Errorf("failed to update finalizers of %T %s: %s... retry after %s", secret, req, err, requeueAfter)
+			return reconcile.Result{RequeueAfter: requeueAfter}, err
+		}
+	}
+
+	after, err := SynchronizeSecret(r.Context, secret)
+	// NOTE: remote clusters failures are reported on the managed secret but
+	//       never fail its local synchronization
+	if remoteAfter, err := synchronizeRemoteClusters(r.Context, secret); err != nil {
+		klog.Errorf("failed to synchronize %T %s on remote clusters: %s... retry after %s", secret, req.NamespacedName, err, requeueAfter)
+		after = minDelay(after, requeueAfter)
+	} else {
+		after = minDelay(after, remoteAfter)
+	}
+	if ignored, _ := isIgnoredSourceNamespace(r.Context, secret.Namespace); !ignored && !dryRun {
+		if err := updateSyncStatus(r.Context, secret, err); err != nil {
+			klog.Errorf("failed to update synchronization status of %T %s: %s", secret, req.NamespacedName, err)
+		}
+	}
+	if err == nil {
+		return reconcile.Result{RequeueAfter: after}, nil
+	}
 	klog.Errorf("failed to synchronize %T %s: %s", secret, req.NamespacedName, err)
 
+	// NOTE: errors which are not retried must not drop the next expected
+	//       synchronization (like an expiration)
 	switch err.(type) {
 	case AnnotationError:
-		return reconcile.Result{}, nil
+		return reconcile.Result{RequeueAfter: after}, nil
 	case RegistryError:
-		return reconcile.Result{}, nil
+		return reconcile.Result{RequeueAfter: after}, nil
+	case PolicyError:
+		return reconcile.Result{RequeueAfter: after}, nil
 	default:
 		return reconcile.Result{RequeueAfter: requeueAfter}, err
 	}
 }
 
+// reconcileRemoteClusters loads the remote clusters again and notifies
+// their update; all managed secrets are then enqueued in order to be
+// synchronized on the new or updated remote clusters.
+func (r *SecretReconciler) reconcileRemoteClusters() (reconcile.Result, error) {
+	if err := r.remoteClusters.refresh(r.Context); err != nil {
+		klog.Errorf("failed to refresh remote clusters: %s... retry after %s", err, requeueAfter)
+		return reconcile.Result{RequeueAfter: requeueAfter}, err
+	}
+
+	r.remoteClusters.notify()
+	return reconcile.Result{}, nil
+}
+
 // SynchronizeSecret duplicates the given secret on namespaces matching with its annotation.
-func SynchronizeSecret(ctx *Context, secret corev1.Secret) error {
+// It returns the delay before the secret must be synchronized again (zero if not required).
+func SynchronizeSecret(ctx *Context, secret corev1.Secret) (time.Duration, error) {
 	name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
-	if funk.ContainsString(ctx.IgnoredNamespaces, secret.Namespace) {
+	if isDryRun(ctx, secret) && !ctx.simulation {
+		ctx = newDryRunContext(ctx)
+	}
+	if ignored, err := isIgnoredSourceNamespace(ctx, secret.Namespace); err != nil {
+		return 0, err
+	} else if ignored {
 		klog.V(3).Infof("namespace %s is ignored, ignore synchronization of %T %s", secret.Namespace, secret, name)
-		return nil
+		return 0, nil
 	}
 
 	ownedSecrets := ctx.registry.OwnedSecretsWithUID(secret.UID)
-	syncedNamespaces := make([]string, len(ownedSecrets))
-	for i, owned := range ownedSecrets {
-		syncedNamespaces[i] = owned.Namespace
+
+	var namespaces []string
+	var denied bool
+	err := checkSourcePolicy(ctx, secret)
+	switch err.(type) {
+	case nil:
+		namespaces, err = listNamespacesFromAnnotations(ctx, secret)
+	case PolicyError:
+		// NOTE: denied secrets are handled like secrets without target
+		//       namespace; all existing owned secrets are removed, whatever
+		//       their deletion policy. They are not registered by the
+		//       bootstrap, so their owned secrets are listed from the cluster.
+		denied = true
+		live, listErr := listOwnedSecrets(ctx, secret)
+		if listErr != nil {
+			return 0, listErr
+		}
+		for _, owned := range live {
+			name := types.NamespacedName{Namespace: owned.Namespace, Name: owned.Name}
+			if !funk.Contains(ownedSecrets, name) {
+				ownedSecrets = append(ownedSecrets, name)
+			}
+		}
+	default:
+		return 0, err
 	}
 
-	namespaces, err := listNamespacesFromAnnotations(ctx, secret)
 	if _, noAnnotation := err.(NoAnnotationError); noAnnotation && len(ownedSecrets) == 0 {
 		// NOTE: if secret doesn't have annotation and doesn't have owned secret,
 		//       this is an unmanaged secret
-		return nil
+		return 0, nil
+	}
+	if _, invalid := err.(AnnotationError); invalid {
+		ctx.recorder.Event(&secret, corev1.EventTypeWarning, AnnotationInvalidReason, err.Error())
 	}
 
-	unsyncedNamespaces := funk.LeftJoinString(syncedNamespaces, namespaces)
+	if isPaused(ctx, secret) {
+		// NOTE: owned secrets are left untouched while the synchronization
+		//       is paused; they are synchronized again once unpaused
+		klog.V(3).Infof("synchronization of %T %s is paused, ignore it", secret, name)
+		return 0, err
+	}
 
-	template := secret.DeepCopy()
-	template.ObjectMeta = metav1.ObjectMeta{
-		Name:        template.Name,
-		Labels:      template.Labels,
-		Annotations: template.Annotations,
-		OwnerReferences: []metav1.OwnerReference{
-			{APIVersion: "v1", Kind: "Secret", Name: secret.Name, UID: secret.UID},
-		},
+	for _, conflict := range ctx.registry.ConflictsWithUID(secret.UID) {
+		if !funk.ContainsString(namespaces, conflict.Namespace) {
+			_ = ctx.registry.UnregisterConflict(conflict)
+		}
 	}
-	template = assignOriginMetadata(template, &secret)
-	template = excludeProtectedMetadata(ctx, template)
 
-	for _, namespace := range unsyncedNamespaces {
+	owner := secret
+	ownerName := name
+	template := newOwnedSecretTemplate(ctx, &owner)
+	recordVersion(ctx, owner, template)
+	templates := ownedSecretTemplates(ctx, owner, template)
+	names := templateNames(templates)
+
+	// NOTE: owned secrets are stale when their namespace is no longer
+	//       synchronized (or when they have expired) or when their name is
+	//       no longer used (versioned name not kept)
+	for _, owned := range ownedSecrets {
+		if funk.ContainsString(namespaces, owned.Namespace) && funk.ContainsString(names, owned.Name) {
+			continue
+		}
+
+		expired, err := isExpiredInNamespace(ctx, owner, owned.Namespace)
+		if err != nil {
+			return 0, err
+		}
+		if expired {
+			secret := template.DeepCopy()
+			secret.Namespace = owned.Namespace
+			secret.Name = owned.Name
+			klog.V(3).Infof("%T %s has expired, delete it", secret, owned)
+			_ = ctx.registry.UnregisterOwnedSecret(owned)
+			if err := deleteOwnedSecret(ctx, secret, ExpiredReason); err != nil && !errors.IsNotFound(err) {
+				ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to delete owned secret %s: %s", owned, err)
+				return 0, ClientError{error: err}
+			}
+			ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, ExpiredReason, "Owned secret %s expired and deleted", owned)
+			continue
+		}
+
+		if !denied && !funk.ContainsString(namespaces, owned.Namespace) && shouldOrphan(ctx, owner, owned.Namespace) {
+			if err := orphanOwnedSecret(ctx, owner, owned); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
 		secret := template.DeepCopy()
-		secret.Namespace = namespace
-		klog.V(3).Infof("delete %T %s/%s", secret, namespace, secret.Name)
-		_ = ctx.registry.UnregisterOwnedSecret(types.NamespacedName{Namespace: namespace, Name: secret.Name})
-		if err := ctx.client.Delete(ctx, secret); err != nil {
-			return ClientError{error: err}
+		secret.Namespace = owned.Namespace
+		secret.Name = owned.Name
+		klog.V(3).Infof("delete %T %s", secret, owned)
+		_ = ctx.registry.UnregisterOwnedSecret(owned)
+		if err := deleteOwnedSecret(ctx, secret, PrunedReason); err != nil && !errors.IsNotFound(err) {
+			ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to delete owned secret %s: %s", owned, err)
+			return 0, ClientError{error: err}
 		}
+		ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, PrunedReason, "Owned secret %s deleted", owned)
 	}
 
 	// NOTE: if an annotation error occurs, we don't need to create or update
 	//       owned secrets.
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if err = ctx.registry.RegisterSecret(name, secret.UID); err != nil {
-		return RegistryError{error: err}
+		return 0, RegistryError{error: err}
 	}
 
-	owner := secret
-	ownerName := name
+	plan, err := planRollout(ctx, owner, template, namespaces)
+	if err != nil {
+		return 0, err
+	}
+	expiration, err := nextExpiration(ctx, owner, namespaces)
+	if err != nil {
+		return 0, err
+	}
+
+	written := false
+	var conflictErr, rolloutErr error
+	// NOTE: workloads are also checked when the owned secret has not
+	//       changed, in order to retry the failed rollouts
+	rollout := func(secret *corev1.Secret, changed bool) {
+		if !isRolloutOnChange(owner) {
+			return
+		}
+		if err := rolloutWorkloads(ctx, owner, secret, changed); err != nil {
+			rolloutErr = err
+		}
+	}
 
 	for _, namespace := range namespaces {
-		secret := &corev1.Secret{}
-		name := types.NamespacedName{Namespace: namespace, Name: name.Name}
+		for _, template := range templates {
+			secret := &corev1.Secret{}
+			name := types.NamespacedName{Namespace: namespace, Name: template.Name}
 
-		klog.V(3).Infof("fetch %T %s", secret, name)
-		err := ctx.client.Get(ctx, name, secret)
-		if errors.IsNotFound(err) {
-			secret := template.DeepCopy()
-			secret.Namespace = namespace
+			klog.V(3).Infof("fetch %T %s", secret, name)
+			err := ctx.client.Get(ctx, name, secret)
+			if errors.IsNotFound(err) {
+				secret := template.DeepCopy()
+				secret.Namespace = namespace
 
-			klog.V(3).Infof("%T %s not found, create it", secret, name)
-			if err := ctx.client.Create(ctx, secret); err != nil {
-				return ClientError{fmt.Errorf("failed to create %T %s: %w", secret, name, err)}
+				klog.V(3).Infof("%T %s not found, create it", secret, name)
+				if err := createOwnedSecret(ctx, secret, SyncedReason); err != nil {
+					ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to create owned secret %s: %s", name, err)
+					return 0, ClientError{fmt.Errorf("failed to create %T %s: %w", secret, name, err)}
+				}
+				_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
+				ctx.recorder.Eventf(secret, corev1.EventTypeNormal, SyncedReason, "Secret synchronized from %s", ownerName)
+				rollout(secret, false)
+				written = true
+				continue
+			} else if err != nil {
+				return 0, ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)}
 			}
-			_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
-			continue
-		} else if err != nil {
-			return ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)}
-		}
 
-		if len(secret.OwnerReferences) == 0 || secret.OwnerReferences[0].UID != owner.UID {
-			klog.V(0).Infof("secret %s not owned by %T %s... ignore", name, secret, ownerName)
-			continue
-		}
+			if len(secret.OwnerReferences) == 0 || secret.OwnerReferences[0].UID != owner.UID {
+				adopted, err := resolveConflict(ctx, owner, secret, template)
+				if err != nil {
+					conflictErr = err
+				}
+				if !adopted {
+					continue
+				}
+			}
+
+			if isSynchronized(secret, template) {
+				klog.V(5).Infof("%T %s already synchronized, ignore update", secret, name)
+				_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
+				rollout(secret, false)
+				continue
+			}
+
+			changed := !hasSameContent(secret, template)
+			if changed && !plan.allows(namespace) {
+				_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
+				previous := plan.previousTemplate(secret)
+				if previous == nil || isSynchronized(secret, previous) {
+					klog.V(3).Infof("%T %s not reached yet by the rollout, keep its content", secret, name)
+					continue
+				}
 
-		secret.SetName(template.GetName())
-		secret.SetNamespace(namespace)
-		secret.SetLabels(template.GetLabels())
-		secret.SetAnnotations(template.GetAnnotations())
-		secret.SetOwnerReferences(template.GetOwnerReferences())
-		secret.StringData = template.StringData
-		secret.Data = template.Data
+				// NOTE: owned secrets not reached yet by the rollout are
+				//       restored to their previous content if tampered
+				klog.V(3).Infof("%T %s not reached yet by the rollout, restore its previous content", secret, name)
+				template = previous
+				changed = !hasSameContent(secret, template)
+			}
+
+			if needsReplacement(secret, template) {
+				desired := template.DeepCopy()
+				desired.Namespace = namespace
+
+				klog.V(3).Infof("%T %s is immutable, replace it", secret, name)
+				if err := replaceOwnedSecret(ctx, secret, desired, SyncedReason); err != nil {
+					ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to replace owned secret %s: %s", name, err)
+					return 0, ClientError{fmt.Errorf("failed to replace %T %s: %w", secret, name, err)}
+				}
+				_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
+				ctx.recorder.Eventf(desired, corev1.EventTypeNormal, SyncedReason, "Secret synchronized from %s", ownerName)
+				rollout(desired, changed)
+				written = true
+				continue
+			}
+
+			secret.SetName(template.GetName())
+			secret.SetNamespace(namespace)
+			secret.SetLabels(template.GetLabels())
+			secret.SetAnnotations(template.GetAnnotations())
+			secret.SetOwnerReferences(template.GetOwnerReferences())
+			secret.Immutable = template.Immutable
+			secret.StringData = template.StringData
+			secret.Data = template.Data
 
-		klog.V(3).Infof("update %T %s", secret, name)
-		if err = ctx.client.Update(ctx, secret); err != nil {
-			return ClientError{fmt.Errorf("failed to update %T %s: %w", secret, name, err)}
+			klog.V(3).Infof("update %T %s", secret, name)
+			if err = updateOwnedSecret(ctx, secret, SyncedReason); err != nil {
+				ctx.recorder.Eventf(&owner, corev1.EventTypeWarning, TargetWriteFailedReason, "Failed to update owned secret %s: %s", name, err)
+				return 0, ClientError{fmt.Errorf("failed to update %T %s: %w", secret, name, err)}
+			}
+			_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
+			ctx.recorder.Eventf(secret, corev1.EventTypeNormal, SyncedReason, "Secret synchronized from %s", ownerName)
+			rollout(secret, changed)
+			written = true
 		}
-		_ = ctx.registry.RegisterOwnedSecret(owner.UID, name)
 	}
-	return nil
+
+	if written {
+		observeSyncLatency(owner)
+		ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, SyncedReason, "Secret synchronized over %d namespace(s)", len(namespaces))
+	}
+	if conflictErr != nil {
+		return minDelay(plan.delay(), expiration), conflictErr
+	}
+	return minDelay(plan.delay(), expiration), rolloutErr
 }
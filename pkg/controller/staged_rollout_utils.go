@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// staged rollout annotations, defining how a content change of the
+	// managed secret is rolled out over its owned secrets
+	RolloutBatchSizeAnnotationKey      = "secret.sync.klst.pw/rollout-batch-size"
+	RolloutIntervalAnnotationKey       = "secret.sync.klst.pw/rollout-interval"
+	RolloutCanarySelectorAnnotationKey = "secret.sync.klst.pw/rollout-canary-selector"
+
+	// staged rollout status annotations, written on the managed secret
+	RolloutProgressAnnotationKey = "secret.sync.klst.pw/rollout-progress"
+	RolloutBatchAtAnnotationKey  = "secret.sync.klst.pw/rollout-batch-at"
+)
+
+type (
+	// rolloutStrategy defines how a content change is rolled out: canary
+	// namespaces are updated first, then the other namespaces by batches
+	// of batchSize, waiting interval between each batch.
+	rolloutStrategy struct {
+		batchSize int
+		interval  time.Duration
+		canary    labels.Selector
+	}
+
+	// rolloutPlan contains the namespaces whose owned secret content can be
+	// updated right now, the delay before the next batch (zero if the
+	// rollout is completed) and the previous contents still rolled out.
+	rolloutPlan struct {
+		allowed      map[string]bool
+		requeueAfter time.Duration
+		previous     map[string]*corev1.Secret
+	}
+)
+
+// parseRolloutStrategy parses the staged rollout annotations of the given
+// managed secret. It returns nil if the secret doesn't define any strategy.
+func parseRolloutStrategy(secret corev1.Secret) (*rolloutStrategy, error) {
+	batchSize, hasBatchSize := secret.Annotations[RolloutBatchSizeAnnotationKey]
+	interval, hasInterval := secret.Annotations[RolloutIntervalAnnotationKey]
+	canary, hasCanary := secret.Annotations[RolloutCanarySelectorAnnotationKey]
+	if !hasBatchSize && !hasCanary {
+		if hasInterval {
+			return nil, fmt.Errorf("'%s' requires '%s' or '%s'", RolloutIntervalAnnotationKey, RolloutBatchSizeAnnotationKey, RolloutCanarySelectorAnnotationKey)
+		}
+		return nil, nil
+	}
+
+	strategy := &rolloutStrategy{}
+	var err error
+	if hasBatchSize {
+		strategy.batchSize, err = strconv.Atoi(batchSize)
+		if err != nil || strategy.batchSize <= 0 {
+			return nil, fmt.Errorf("'%s' is not a positive integer", RolloutBatchSizeAnnotationKey)
+		}
+	}
+	if hasInterval {
+		strategy.interval, err = time.ParseDuration(interval)
+		if err != nil || strategy.interval < 0 {
+			return nil, fmt.Errorf("'%s' is not a valid duration", RolloutIntervalAnnotationKey)
+		}
+	}
+	if hasCanary {
+		strategy.canary, err = labels.Parse(canary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", RolloutCanarySelectorAnnotationKey, err)
+		}
+	}
+	return strategy, nil
+}
+
+// allows returns true if the content of the owned secret in the given
+// namespace can be updated. A nil plan allows all namespaces.
+func (p *rolloutPlan) allows(namespace string) bool {
+	return p == nil || p.allowed[namespace]
+}
+
+// previousTemplate returns the template of the previous content kept by
+// the given owned secret, not reached yet by the rollout, or nil if the
+// owned secret is not part of the rollout.
+func (p *rolloutPlan) previousTemplate(secret *corev1.Secret) *corev1.Secret {
+	if p == nil {
+		return nil
+	}
+	return p.previous[secret.Annotations[SourceHashAnnotationKey]]
+}
+
+// isPending returns true if the given owned secret has not been reached
+// yet by the rollout and still keeps its previous content untouched.
+func (p *rolloutPlan) isPending(secret *corev1.Secret) bool {
+	previous := p.previousTemplate(secret)
+	return previous != nil && isSynchronized(secret, previous)
+}
+
+// previousTemplates returns the templates of the previous contents which
+// are still rolled out, indexed by their source hash. They are rebuilt from
+// the given owned secrets which have not been reached yet by the rollout;
+// only untouched owned secrets are used, so a tampered owned secret is never
+// considered as part of a rollout by itself.
+func previousTemplates(secrets []*corev1.Secret, template *corev1.Secret) map[string]*corev1.Secret {
+	current := template.Annotations[SourceHashAnnotationKey]
+	previous := map[string]*corev1.Secret{}
+	for _, secret := range secrets {
+		hash := secret.Annotations[SourceHashAnnotationKey]
+		if secret.Name != template.Name || hash == current || hasSameContent(secret, template) || hashSecret(secret) != hash {
+			continue
+		}
+		if _, exists := previous[hash]; !exists {
+			previous[hash] = versionTemplate(*secret)
+		}
+	}
+	return previous
+}
+
+// delay returns the delay before the next batch. A nil plan doesn't have
+// any next batch.
+func (p *rolloutPlan) delay() time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.requeueAfter
+}
+
+// planRollout plans the rollout of the content of the given managed secret
+// over the owned secrets of the given namespaces, based on its staged
+// rollout strategy. Only existing owned secrets which keep a previous
+// content are staged; missing and tampered owned secrets are always
+// synchronized. The progress is written on the managed secret, in order to
+// resume the rollout after a restart. Staged rollouts don't apply to remote
+// clusters.
+func planRollout(ctx *Context, owner corev1.Secret, template *corev1.Secret, namespaces []string) (*rolloutPlan, error) {
+	if ctx.cluster != "" {
+		return nil, nil
+	}
+	strategy, err := parseRolloutStrategy(owner)
+	if err != nil || strategy == nil {
+		return nil, err
+	}
+
+	var owned []*corev1.Secret
+	for _, namespace := range namespaces {
+		name := types.NamespacedName{Namespace: namespace, Name: template.Name}
+		secret := &corev1.Secret{}
+		err := ctx.client.Get(ctx, name, secret)
+		switch {
+		case errors.IsNotFound(err):
+			continue
+		case err != nil:
+			return nil, ClientError{fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)}
+		case len(secret.OwnerReferences) == 0 || secret.OwnerReferences[0].UID != owner.UID:
+			continue
+		}
+		owned = append(owned, secret)
+	}
+
+	plan := &rolloutPlan{allowed: map[string]bool{}, previous: previousTemplates(owned, template)}
+	var pending, canaries []string
+	updated := 0
+	for _, secret := range owned {
+		namespace := secret.Namespace
+		if hasSameContent(secret, template) || plan.previousTemplate(secret) == nil {
+			// NOTE: owned secrets which are not part of the rollout (like
+			//       tampered ones) are synchronized right now
+			plan.allowed[namespace] = true
+			updated++
+			continue
+		}
+
+		if strategy.canary != nil {
+			ns := corev1.Namespace{}
+			if err := getNamespace(ctx, namespace, &ns); err != nil && !errors.IsNotFound(err) {
+				return nil, ClientError{fmt.Errorf("failed to fetch %T %s: %w", ns, namespace, err)}
+			} else if err == nil && strategy.canary.Matches(labels.Set(ns.Labels)) {
+				canaries = append(canaries, namespace)
+				continue
+			}
+		}
+		pending = append(pending, namespace)
+	}
+
+	total := updated + len(canaries) + len(pending)
+	if len(canaries)+len(pending) == 0 {
+		return plan, writeRolloutStatus(ctx, owner, fmt.Sprintf("%d/%d", updated, total), "")
+	}
+
+	now := time.Now()
+	if batchAt, err := time.Parse(time.RFC3339, owner.Annotations[RolloutBatchAtAnnotationKey]); err == nil {
+		if next := batchAt.Add(strategy.interval); now.Before(next) {
+			klog.V(3).Infof("next rollout batch of %T %s/%s in %s", owner, owner.Namespace, owner.Name, next.Sub(now))
+			plan.requeueAfter = next.Sub(now)
+			return plan, nil
+		}
+	}
+
+	// NOTE: canary namespaces are always updated first, in a single batch
+	sort.Strings(canaries)
+	sort.Strings(pending)
+	batch := canaries
+	if len(batch) == 0 {
+		batch = pending
+		if strategy.batchSize > 0 && len(batch) > strategy.batchSize {
+			batch = batch[:strategy.batchSize]
+		}
+	}
+	for _, namespace := range batch {
+		plan.allowed[namespace] = true
+	}
+	if len(batch) < len(canaries)+len(pending) {
+		plan.requeueAfter = strategy.interval
+		if plan.requeueAfter < time.Second {
+			plan.requeueAfter = time.Second
+		}
+	}
+
+	progress := fmt.Sprintf("%d/%d", updated+len(batch), total)
+	ctx.recorder.Eventf(&owner, corev1.EventTypeNormal, RolloutBatchReason, "Rolling out on %d namespace(s) %v (%s)", len(batch), batch, progress)
+	return plan, writeRolloutStatus(ctx, owner, progress, now.UTC().Format(time.RFC3339))
+}
+
+// writeRolloutStatus writes the rollout progress and the time of the last
+// batch (if not empty) on the given managed secret.
+func writeRolloutStatus(ctx *Context, owner corev1.Secret, progress, batchAt string) error {
+	if ctx.simulation {
+		return nil
+	}
+
+	status := owner.DeepCopy()
+	if status.Annotations == nil {
+		status.Annotations = map[string]string{}
+	}
+	status.Annotations[RolloutProgressAnnotationKey] = progress
+	if batchAt != "" {
+		status.Annotations[RolloutBatchAtAnnotationKey] = batchAt
+	}
+	if status.Annotations[RolloutProgressAnnotationKey] == owner.Annotations[RolloutProgressAnnotationKey] &&
+		status.Annotations[RolloutBatchAtAnnotationKey] == owner.Annotations[RolloutBatchAtAnnotationKey] {
+		return nil
+	}
+
+	klog.V(3).Infof("update rollout status of %T %s/%s: %s", owner, owner.Namespace, owner.Name, progress)
+	if err := ctx.client.Patch(ctx, status, client.MergeFrom(&owner)); err != nil {
+		return ClientError{fmt.Errorf("failed to update rollout status of %T %s/%s: %w", owner, owner.Namespace, owner.Name, err)}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// synchronization status annotations, written on the managed secret
+	SyncedNamespacesAnnotationKey = "secret.sync.klst.pw/synced-namespaces"
+	LastSyncedAtAnnotationKey     = "secret.sync.klst.pw/last-synced-at"
+	SyncErrorsAnnotationKey       = "secret.sync.klst.pw/sync-errors"
+	ObservedHashAnnotationKey     = "secret.sync.klst.pw/observed-hash"
+	ObservedAtAnnotationKey       = "secret.sync.klst.pw/observed-at"
+)
+
+// syncStatusAnnotationKeys lists all annotations managed by the controller
+// on the managed secret.
+var syncStatusAnnotationKeys = []string{
+	SyncedNamespacesAnnotationKey,
+	LastSyncedAtAnnotationKey,
+	SyncErrorsAnnotationKey,
+	ObservedHashAnnotationKey,
+	ObservedAtAnnotationKey,
+	RolloutProgressAnnotationKey,
+	RolloutBatchAtAnnotationKey,
+}
+
+// updateSyncStatus writes the synchronization status of the given secret on
+// its annotations, based on the result of the synchronization. The secret is
+// patched only if its status has changed.
+func updateSyncStatus(ctx *Context, secret corev1.Secret, syncErr error) error {
+	status := secret.DeepCopy()
+	if status.Annotations == nil {
+		status.Annotations = map[string]string{}
+	}
+
+	if _, noAnnotation := syncErr.(NoAnnotationError); noAnnotation || !hasSyncAnnotations(secret) {
+		// NOTE: unmanaged secrets must not have any synchronization status
+		for _, key := range syncStatusAnnotationKeys {
+			delete(status.Annotations, key)
+		}
+	} else {
+		var namespaces []string
+		for _, owned := range ctx.registry.OwnedSecretsWithUID(secret.UID) {
+			namespaces = append(namespaces, owned.Namespace)
+		}
+		sort.Strings(namespaces)
+
+		status.Annotations[SyncedNamespacesAnnotationKey] = strings.Join(namespaces, ",")
+		// NOTE: the content of a paused secret is not synchronized, so
+		//       the observed hash and the synchronization date are kept
+		paused := isPaused(ctx, secret)
+		if hash := newOwnedSecretTemplate(ctx, &secret).Annotations[SourceHashAnnotationKey]; !paused &&
+			(status.Annotations[ObservedHashAnnotationKey] != hash || status.Annotations[ObservedAtAnnotationKey] == "") {
+			// NOTE: the observed date is the first synchronization of the
+			//       observed hash, the TTL being relative to it
+			status.Annotations[ObservedHashAnnotationKey] = hash
+			status.Annotations[ObservedAtAnnotationKey] = contentSyncedAt(ctx, secret).UTC().Format(time.RFC3339)
+		}
+		if syncErr != nil {
+			status.Annotations[SyncErrorsAnnotationKey] = syncErr.Error()
+		} else {
+			delete(status.Annotations, SyncErrorsAnnotationKey)
+		}
+
+		// NOTE: the synchronization date is only updated when something has
+		//       changed, in order to avoid infinite reconciliation loops
+		_, synced := status.Annotations[LastSyncedAtAnnotationKey]
+		if syncErr == nil && !paused && (!synced || !equality.Semantic.DeepEqual(status.Annotations, secret.Annotations)) {
+			status.Annotations[LastSyncedAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+		}
+	}
+
+	if equality.Semantic.DeepEqual(status.Annotations, secret.Annotations) ||
+		(len(status.Annotations) == 0 && len(secret.Annotations) == 0) {
+		return nil
+	}
+
+	klog.V(3).Infof("update synchronization status of %T %s/%s", secret, secret.Namespace, secret.Name)
+	if err := ctx.client.Patch(ctx, status, client.MergeFrom(&secret)); err != nil {
+		return ClientError{fmt.Errorf("failed to update synchronization status of %T %s/%s: %w", secret, secret.Namespace, secret.Name, err)}
+	}
+	return nil
+}
+
+// hasSyncAnnotations returns true if the secret has at least one of the
+// synchronization annotations.
+func hasSyncAnnotations(secret corev1.Secret) bool {
+	_, hasAllNamespace := secret.Annotations[NamespaceAllAnnotationKey]
+	_, hasNamespaceSelector := secret.Annotations[NamespaceSelectorAnnotationKey]
+	return hasAllNamespace || hasNamespaceSelector
+}
+
+// withoutSyncStatus returns a copy of the given secret without the
+// synchronization status annotations.
+func withoutSyncStatus(secret *corev1.Secret) *corev1.Secret {
+	secret = secret.DeepCopy()
+	for _, key := range syncStatusAnnotationKeys {
+		delete(secret.Annotations, key)
+	}
+	return secret
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// VersionHistoryAnnotationKey is the annotation defining how many
+	// versions of the owned secrets (the current one included) are kept in
+	// each namespace, when their name is versioned.
+	VersionHistoryAnnotationKey = "secret.sync.klst.pw/version-history"
+	// StableNameAnnotationKey is the annotation used to also write the owned
+	// secrets with the stable name, when their name is versioned.
+	StableNameAnnotationKey = "secret.sync.klst.pw/stable-name"
+)
+
+// isVersioned returns true if the names of the owned secrets of the given
+// managed secret are versioned.
+func isVersioned(secret corev1.Secret) bool {
+	versioned, _ := strconv.ParseBool(secret.Annotations[VersionedNameAnnotationKey])
+	return versioned
+}
+
+// isIntactVersion returns true if the given owned secret has not been
+// tampered with and is named after its hash, so it can be trusted as a
+// version of the owned secrets of the given origin.
+func isIntactVersion(secret corev1.Secret, origin string) bool {
+	hash := secret.Annotations[SourceHashAnnotationKey]
+	return secret.Name != origin && hash != "" && hashSecret(&secret) == hash && secret.Name == versionedName(origin, hash)
+}
+
+// parseVersionHistory returns how many versions of the owned secrets of the
+// given managed secret must be kept (default to 1, the current version).
+func parseVersionHistory(secret corev1.Secret) (int, error) {
+	value, exists := secret.Annotations[VersionHistoryAnnotationKey]
+	if !exists {
+		return 1, nil
+	}
+
+	history, err := strconv.Atoi(value)
+	if err != nil || history <= 0 {
+		return 0, fmt.Errorf("'%s' is not a positive integer", VersionHistoryAnnotationKey)
+	}
+	return history, nil
+}
+
+// recordVersion registers the given template as the most recent version of
+// the owned secrets of the given managed secret, and forgets the versions
+// which are no longer kept; their owned secrets become stale and are pruned.
+func recordVersion(ctx *Context, owner corev1.Secret, template *corev1.Secret) {
+	versions := ctx.registry.VersionsWithUID(owner.UID)
+	if !isVersioned(owner) {
+		for _, version := range versions {
+			_ = ctx.registry.UnregisterVersion(owner.UID, version.Name)
+		}
+		return
+	}
+
+	ctx.registry.RegisterVersion(owner.UID, template)
+	versions = ctx.registry.VersionsWithUID(owner.UID)
+	history, _ := parseVersionHistory(owner)
+	if excess := len(versions) - history; excess > 0 {
+		for _, version := range versions[:excess] {
+			_ = ctx.registry.UnregisterVersion(owner.UID, version.Name)
+		}
+	}
+}
+
+// ownedSecretTemplates returns the templates of all owned secrets which must
+// exist in each synchronized namespace: the given (current) template and, if
+// the names are versioned, the owned secret with the stable name and the
+// previous versions kept, from the most recent to the oldest.
+func ownedSecretTemplates(ctx *Context, owner corev1.Secret, template *corev1.Secret) []*corev1.Secret {
+	templates := []*corev1.Secret{template}
+	if !isVersioned(owner) {
+		return templates
+	}
+
+	if stable, _ := strconv.ParseBool(owner.Annotations[StableNameAnnotationKey]); stable {
+		stableTemplate := template.DeepCopy()
+		stableTemplate.Name = owner.Name
+		templates = append(templates, stableTemplate)
+	}
+
+	history, _ := parseVersionHistory(owner)
+	versions := ctx.registry.VersionsWithUID(owner.UID)
+	for i, previous := len(versions)-1, 0; i >= 0 && previous < history-1; i-- {
+		if versions[i].Name != template.Name {
+			templates = append(templates, versions[i].DeepCopy())
+			previous++
+		}
+	}
+	return templates
+}
+
+// ownedSecretTemplate returns the template of the owned secret with the
+// given name, or nil if no owned secret with this name must exist.
+func ownedSecretTemplate(ctx *Context, owner corev1.Secret, name string) *corev1.Secret {
+	for _, template := range ownedSecretTemplates(ctx, owner, newOwnedSecretTemplate(ctx, &owner)) {
+		if template.Name == name {
+			return template
+		}
+	}
+	return nil
+}
+
+// templateNames returns the names of all given templates.
+func templateNames(templates []*corev1.Secret) []string {
+	names := make([]string, 0, len(templates))
+	for _, template := range templates {
+		names = append(names, template.Name)
+	}
+	return names
+}
+
+// versionTemplate rebuilds the template of a version from one of its owned
+// secrets.
+func versionTemplate(secret corev1.Secret) *corev1.Secret {
+	template := secret.DeepCopy()
+	template.ObjectMeta = metav1.ObjectMeta{
+		Name:            secret.Name,
+		Labels:          secret.Labels,
+		Annotations:     secret.Annotations,
+		OwnerReferences: secret.OwnerReferences,
+	}
+	return template
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsIntactVersion(t *testing.T) {
+	intact := func() corev1.Secret {
+		secret := corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:      map[string]string{OriginNameLabelsKey: "secret", OriginNamespaceLabelsKey: "default"},
+				Annotations: map[string]string{},
+			},
+			Data: map[string][]byte{"username": []byte("my-app")},
+		}
+		secret.Annotations[SourceHashAnnotationKey] = hashSecret(&secret)
+		secret.Name = versionedName("secret", secret.Annotations[SourceHashAnnotationKey])
+		return secret
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(secret *corev1.Secret)
+		intact bool
+	}{
+		{"WithIntactVersion", func(*corev1.Secret) {}, true},
+		{"WithStableName", func(secret *corev1.Secret) { secret.Name = "secret" }, false},
+		{"WithTamperedData", func(secret *corev1.Secret) { secret.Data["username"] = []byte("root") }, false},
+		{"WithoutHash", func(secret *corev1.Secret) { delete(secret.Annotations, SourceHashAnnotationKey) }, false},
+		{"WithShortHash", func(secret *corev1.Secret) { secret.Annotations[SourceHashAnnotationKey] = "0" }, false},
+		{"WithAnotherName", func(secret *corev1.Secret) { secret.Name = "secret-00000000" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := intact()
+			tt.tamper(&secret)
+			assert.Equal(t, tt.intact, isIntactVersion(secret, "secret"))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package registry
 import (
 	"sync"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -20,6 +21,14 @@ type (
 		secretsByOwnedSecretName map[types.NamespacedName]*Secret
 		// ownedSecretsBySecretUID maps all owned secrets with the owner secret UID
 		ownedSecretsBySecretUID map[types.UID][]types.NamespacedName
+		// conflictsBySecretName maps all conflicting secrets (secrets which
+		// already exist but are not owned by the managed secret) with the
+		// managed secret UID
+		conflictsBySecretName map[types.NamespacedName]types.UID
+		// versionsBySecretUID maps all versions of the owned secrets (when
+		// their name is versioned), from the oldest to the most recent,
+		// with the managed secret UID
+		versionsBySecretUID map[types.UID][]*corev1.Secret
 
 		mx sync.RWMutex
 	}
@@ -35,10 +44,41 @@ func New() *Registry {
 		secretsByUID:             map[types.UID]*Secret{},
 		secretsByOwnedSecretName: map[types.NamespacedName]*Secret{},
 		ownedSecretsBySecretUID:  map[types.UID][]types.NamespacedName{},
+		conflictsBySecretName:    map[types.NamespacedName]types.UID{},
+		versionsBySecretUID:      map[types.UID][]*corev1.Secret{},
 		mx:                       sync.RWMutex{},
 	}
 }
 
+// Copy returns a deep copy of the registry; changes made on the copy don't
+// affect the original registry.
+func (r *Registry) Copy() *Registry {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	c := New()
+	for uid, secret := range r.secretsByUID {
+		c.secretsByUID[uid] = &Secret{NamespacedName: secret.NamespacedName, UID: secret.UID}
+	}
+	for name, secret := range r.secretsByOwnedSecretName {
+		if copied, exists := c.secretsByUID[secret.UID]; exists {
+			c.secretsByOwnedSecretName[name] = copied
+		}
+	}
+	for uid, names := range r.ownedSecretsBySecretUID {
+		c.ownedSecretsBySecretUID[uid] = append([]types.NamespacedName(nil), names...)
+	}
+	for name, uid := range r.conflictsBySecretName {
+		c.conflictsBySecretName[name] = uid
+	}
+	for uid, versions := range r.versionsBySecretUID {
+		for _, version := range versions {
+			c.versionsBySecretUID[uid] = append(c.versionsBySecretUID[uid], version.DeepCopy())
+		}
+	}
+	return c
+}
+
 // Secrets returns all register secret's names.
 func (r *Registry) Secrets() []types.NamespacedName {
 	r.mx.RLock()
@@ -85,6 +125,19 @@ func (r *Registry) SecretWithOwnedSecretName(ownedSecretName types.NamespacedNam
 	return secret
 }
 
+// SecretWithConflictName returns the registered secret in conflict with the
+// given secret name, or nil if doesn't exists.
+func (r *Registry) SecretWithConflictName(conflictName types.NamespacedName) *Secret {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	uid, exists := r.conflictsBySecretName[conflictName]
+	if !exists {
+		return nil
+	}
+	return r.secretsByUID[uid]
+}
+
 // Secrets returns all register owned secret's names.
 func (r *Registry) OwnedSecretsWithUID(uid types.UID) []types.NamespacedName {
 	r.mx.RLock()
@@ -93,6 +146,70 @@ func (r *Registry) OwnedSecretsWithUID(uid types.UID) []types.NamespacedName {
 	return r.ownedSecretsBySecretUID[uid]
 }
 
+// OwnedSecrets returns all register owned secret's names.
+func (r *Registry) OwnedSecrets() []types.NamespacedName {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	var ownedSecrets []types.NamespacedName
+	for name := range r.secretsByOwnedSecretName {
+		ownedSecrets = append(ownedSecrets, name)
+	}
+	return ownedSecrets
+}
+
+// Conflicts returns all register conflicting secret's names.
+func (r *Registry) Conflicts() []types.NamespacedName {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	var conflicts []types.NamespacedName
+	for name := range r.conflictsBySecretName {
+		conflicts = append(conflicts, name)
+	}
+	return conflicts
+}
+
+// ConflictsWithUID returns all register conflicting secret's names of the
+// given managed secret.
+func (r *Registry) ConflictsWithUID(uid types.UID) []types.NamespacedName {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	var conflicts []types.NamespacedName
+	for name, managerUID := range r.conflictsBySecretName {
+		if managerUID == uid {
+			conflicts = append(conflicts, name)
+		}
+	}
+	return conflicts
+}
+
+// VersionsWithUID returns all registered versions of the owned secrets of
+// the given managed secret, from the oldest to the most recent. Returned
+// versions must not be modified.
+func (r *Registry) VersionsWithUID(uid types.UID) []*corev1.Secret {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return append([]*corev1.Secret(nil), r.versionsBySecretUID[uid]...)
+}
+
+// VersionWithName returns the registered version of the owned secrets of
+// the given managed secret with the given name, or nil if doesn't exists.
+// The returned version must not be modified.
+func (r *Registry) VersionWithName(uid types.UID, name string) *corev1.Secret {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	for _, version := range r.versionsBySecretUID[uid] {
+		if version.Name == name {
+			return version
+		}
+	}
+	return nil
+}
+
 // secretWithName returns a registered secret with the given name, or nil
 // if doesn't exists.
 func (r *Registry) secretWithName(name string) *Secret {
@@ -142,6 +259,12 @@ func (r *Registry) UnregisterSecret(uid types.UID) error {
 		delete(r.secretsByOwnedSecretName, name)
 	}
 	delete(r.ownedSecretsBySecretUID, uid)
+	delete(r.versionsBySecretUID, uid)
+	for name, managerUID := range r.conflictsBySecretName {
+		if managerUID == uid {
+			delete(r.conflictsBySecretName, name)
+		}
+	}
 	r.mx.Unlock()
 
 	return nil
@@ -161,6 +284,7 @@ func (r *Registry) RegisterOwnedSecret(managerUID types.UID, name types.Namespac
 	r.mx.Lock()
 	r.secretsByOwnedSecretName[name] = secret
 	r.ownedSecretsBySecretUID[managerUID] = append(r.ownedSecretsBySecretUID[managerUID], name)
+	delete(r.conflictsBySecretName, name)
 	r.mx.Unlock()
 
 	return nil
@@ -192,3 +316,62 @@ func (r *Registry) UnregisterOwnedSecret(name types.NamespacedName) error {
 
 	return nil
 }
+
+// RegisterConflict adds a new conflicting secret to the registry. A
+// conflicting secret is a secret which must be synced by the given managed
+// secret but which is not owned by it.
+func (r *Registry) RegisterConflict(managerUID types.UID, name types.NamespacedName) error {
+	if r.SecretWithUID(managerUID) == nil {
+		return SecretNotFoundErr{field: "UID", value: string(managerUID)}
+	}
+
+	r.mx.Lock()
+	r.conflictsBySecretName[name] = managerUID
+	r.mx.Unlock()
+
+	return nil
+}
+
+// UnregisterConflict removes a conflicting secret from the registry.
+func (r *Registry) UnregisterConflict(name types.NamespacedName) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if _, exists := r.conflictsBySecretName[name]; !exists {
+		return SecretNotFoundErr{field: "conflicting secret name", value: name.String()}
+	}
+	delete(r.conflictsBySecretName, name)
+	return nil
+}
+
+// RegisterVersion adds a new version of the owned secrets of the given
+// managed secret to the registry, as the most recent one. If a version with
+// the same name already exists, it becomes the most recent one.
+func (r *Registry) RegisterVersion(managerUID types.UID, version *corev1.Secret) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	versions := make([]*corev1.Secret, 0, len(r.versionsBySecretUID[managerUID])+1)
+	for _, registered := range r.versionsBySecretUID[managerUID] {
+		if registered.Name != version.Name {
+			versions = append(versions, registered)
+		}
+	}
+	r.versionsBySecretUID[managerUID] = append(versions, version.DeepCopy())
+}
+
+// UnregisterVersion removes a version of the owned secrets of the given
+// managed secret from the registry.
+func (r *Registry) UnregisterVersion(managerUID types.UID, name string) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	versions := r.versionsBySecretUID[managerUID]
+	for i, version := range versions {
+		if version.Name == name {
+			r.versionsBySecretUID[managerUID] = append(versions[:i:i], versions[i+1:]...)
+			return nil
+		}
+	}
+	return SecretNotFoundErr{field: "version name", value: name}
+}
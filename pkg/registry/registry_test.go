@@ -8,6 +8,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -42,6 +44,24 @@ func TestRegistry_New(t *testing.T) {
 	assert.NotNil(t, registry.secretsByOwnedSecretName)
 	assert.NotNil(t, registry.secretsByUID)
 	assert.NotNil(t, registry.ownedSecretsBySecretUID)
+	assert.NotNil(t, registry.conflictsBySecretName)
+	assert.NotNil(t, registry.versionsBySecretUID)
+}
+
+func TestRegistry_Copy(t *testing.T) {
+	copied := registry.Copy()
+	assert.Equal(t, registry.Secrets(), copied.Secrets())
+	assert.ElementsMatch(t, registry.OwnedSecrets(), copied.OwnedSecrets())
+	assert.Same(t, copied.SecretWithUID(secret.UID), copied.SecretWithOwnedSecretName(types.NamespacedName{Namespace: "custom", Name: "test"}))
+
+	t.Run("WithChangesOnCopy", func(t *testing.T) {
+		require.NoError(t, copied.UnregisterOwnedSecret(types.NamespacedName{Namespace: "custom", Name: "test"}))
+		require.NoError(t, copied.RegisterConflict(secret.UID, types.NamespacedName{Namespace: "kube-system", Name: "conflict"}))
+
+		assert.Len(t, registry.OwnedSecretsWithUID(secret.UID), 3)
+		assert.NotNil(t, registry.SecretWithOwnedSecretName(types.NamespacedName{Namespace: "custom", Name: "test"}))
+		assert.Empty(t, registry.Conflicts())
+	})
 }
 
 func TestRegistry_Secrets(t *testing.T) {
@@ -120,6 +140,14 @@ func TestRegistry_OwnedSecretWithUID(t *testing.T) {
 	}
 }
 
+func TestRegistry_OwnedSecrets(t *testing.T) {
+	assert.ElementsMatch(t, registry.OwnedSecrets(), []types.NamespacedName{
+		{Namespace: "kube-system", Name: "test"},
+		{Namespace: "kube-public", Name: "test"},
+		{Namespace: "custom", Name: "test"},
+	})
+}
+
 func TestRegistry_RegisterSecret(t *testing.T) {
 	registry := New()
 
@@ -313,3 +341,127 @@ func TestRegistry_UnregisterOwnedSecret(t *testing.T) {
 		assert.Empty(t, registry.ownedSecretsBySecretUID[secret.UID])
 	})
 }
+
+func TestRegistry_RegisterConflict(t *testing.T) {
+	registry := New()
+
+	// preflight checks
+	require.NoError(t, registry.RegisterSecret(secret.NamespacedName, secret.UID))
+	require.Empty(t, registry.conflictsBySecretName)
+
+	t.Run("WithInvalidSecretUID", func(t *testing.T) {
+		assert.EqualError(
+			t,
+			registry.RegisterConflict(
+				"00000000-0000-0000-0000-000000000000",
+				types.NamespacedName{Namespace: "kube-system", Name: "test"},
+			),
+			"secret with the given UID '00000000-0000-0000-0000-000000000000' not found",
+		)
+	})
+
+	t.Run("WithNewConflict", func(t *testing.T) {
+		assert.NoError(t, registry.RegisterConflict(secret.UID, types.NamespacedName{Namespace: "kube-system", Name: "test"}))
+		assert.Equal(t, []types.NamespacedName{{Namespace: "kube-system", Name: "test"}}, registry.Conflicts())
+		assert.Equal(t, []types.NamespacedName{{Namespace: "kube-system", Name: "test"}}, registry.ConflictsWithUID(secret.UID))
+		assert.Equal(t, secret.UID, registry.SecretWithConflictName(types.NamespacedName{Namespace: "kube-system", Name: "test"}).UID)
+		assert.Nil(t, registry.SecretWithConflictName(types.NamespacedName{Namespace: "kube-public", Name: "test"}))
+	})
+
+	t.Run("WithOwnedSecretRegistered", func(t *testing.T) {
+		//NOTE: a conflict is resolved as soon as the secret is owned
+		assert.NoError(t, registry.RegisterOwnedSecret(secret.UID, types.NamespacedName{Namespace: "kube-system", Name: "test"}))
+		assert.Empty(t, registry.Conflicts())
+	})
+
+	t.Run("WithSecretUnregistered", func(t *testing.T) {
+		require.NoError(t, registry.RegisterConflict(secret.UID, types.NamespacedName{Namespace: "kube-public", Name: "test"}))
+		assert.NoError(t, registry.UnregisterSecret(secret.UID))
+		assert.Empty(t, registry.conflictsBySecretName)
+	})
+}
+
+func TestRegistry_UnregisterConflict(t *testing.T) {
+	registry := New()
+
+	// preflight checks
+	require.NoError(t, registry.RegisterSecret(secret.NamespacedName, secret.UID))
+	require.NoError(t, registry.RegisterConflict(secret.UID, types.NamespacedName{Namespace: "kube-system", Name: "test"}))
+	require.Contains(t, registry.conflictsBySecretName, types.NamespacedName{Namespace: "kube-system", Name: "test"})
+
+	t.Run("WithRegisteredConflict", func(t *testing.T) {
+		assert.NoError(t, registry.UnregisterConflict(types.NamespacedName{Namespace: "kube-system", Name: "test"}))
+	})
+
+	t.Run("WithUnregisteredConflict", func(t *testing.T) {
+		assert.EqualError(
+			t,
+			registry.UnregisterConflict(types.NamespacedName{Namespace: "kube-system", Name: "test"}),
+			"secret with the given conflicting secret name '"+types.NamespacedName{Namespace: "kube-system", Name: "test"}.String()+"' not found",
+		)
+	})
+
+	t.Run("VerifyInternalState", func(t *testing.T) {
+		assert.Empty(t, registry.conflictsBySecretName)
+		assert.Len(t, registry.secretsByUID, 1)
+	})
+}
+
+func TestRegistry_RegisterVersion(t *testing.T) {
+	registry := New()
+	v1 := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-00000001"}}
+	v2 := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-00000002"}}
+
+	t.Run("WithNewVersions", func(t *testing.T) {
+		registry.RegisterVersion(secret.UID, v1)
+		registry.RegisterVersion(secret.UID, v2)
+
+		versions := registry.VersionsWithUID(secret.UID)
+		require.Len(t, versions, 2)
+		assert.Equal(t, "test-00000001", versions[0].Name)
+		assert.Equal(t, "test-00000002", versions[1].Name)
+		assert.NotSame(t, v1, registry.VersionWithName(secret.UID, "test-00000001"))
+	})
+
+	t.Run("WithExistingVersion", func(t *testing.T) {
+		registry.RegisterVersion(secret.UID, v1)
+
+		versions := registry.VersionsWithUID(secret.UID)
+		require.Len(t, versions, 2)
+		assert.Equal(t, "test-00000002", versions[0].Name)
+		assert.Equal(t, "test-00000001", versions[1].Name)
+	})
+
+	t.Run("WithUnregisteredSecret", func(t *testing.T) {
+		require.NoError(t, registry.RegisterSecret(secret.NamespacedName, secret.UID))
+		require.NoError(t, registry.UnregisterSecret(secret.UID))
+		assert.Empty(t, registry.VersionsWithUID(secret.UID))
+		assert.Nil(t, registry.VersionWithName(secret.UID, "test-00000001"))
+	})
+}
+
+func TestRegistry_UnregisterVersion(t *testing.T) {
+	registry := New()
+
+	// preflight checks
+	registry.RegisterVersion(secret.UID, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-00000001"}})
+	registry.RegisterVersion(secret.UID, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-00000002"}})
+	require.Len(t, registry.versionsBySecretUID[secret.UID], 2)
+
+	t.Run("WithRegisteredVersion", func(t *testing.T) {
+		assert.NoError(t, registry.UnregisterVersion(secret.UID, "test-00000001"))
+	})
+
+	t.Run("WithUnregisteredVersion", func(t *testing.T) {
+		assert.EqualError(
+			t,
+			registry.UnregisterVersion(secret.UID, "test-00000001"),
+			"secret with the given version name 'test-00000001' not found",
+		)
+	})
+
+	t.Run("VerifyInternalState", func(t *testing.T) {
+		require.Len(t, registry.versionsBySecretUID[secret.UID], 1)
+		assert.Equal(t, "test-00000002", registry.versionsBySecretUID[secret.UID][0].Name)
+	})
+}
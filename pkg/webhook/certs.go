@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// CACertName is the name of the CA certificate file.
+	CACertName = "ca.crt"
+	// CertName is the name of the serving certificate file.
+	CertName = "tls.crt"
+	// KeyName is the name of the serving key file.
+	KeyName = "tls.key"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+	// certRenewBefore is the duration before the expiration of the
+	// serving certificate where a new certificate is generated.
+	certRenewBefore = 30 * 24 * time.Hour
+	// certRenewalPeriod is the period of the certificate renewal checks.
+	certRenewalPeriod = time.Hour
+)
+
+// Certificates contains the PEM encoded certificates used by the webhook
+// server.
+type Certificates struct {
+	CACert []byte
+	Cert   []byte
+	Key    []byte
+}
+
+// GenerateCertificates generates a self-signed CA and a serving certificate,
+// signed by this CA, valid for the given DNS names.
+func GenerateCertificates(dnsNames []string) (*Certificates, error) {
+	return generateCertificates(dnsNames, certValidity)
+}
+
+// generateCertificates generates a self-signed CA and a serving certificate
+// valid for the given duration.
+func generateCertificates(dnsNames []string, validity time.Duration) (*Certificates, error) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sync-secrets-controller-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA certificate: %w", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serving key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serving certificate: %w", err)
+	}
+
+	return &Certificates{
+		CACert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:    pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}, nil
+}
+
+// IsValidFor returns true if the serving certificate is valid for all the
+// given DNS names and doesn't need to be renewed.
+func (c *Certificates) IsValidFor(dnsNames []string) bool {
+	block, _ := pem.Decode(c.Cert)
+	if block == nil || len(c.Key) == 0 || len(c.CACert) == 0 {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || time.Now().Add(certRenewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// validCACert returns the first CA certificate of the given PEM encoded CA
+// bundle, if it is not expired yet.
+func validCACert(bundle []byte) []byte {
+	block, _ := pem.Decode(bundle)
+	if block == nil {
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || time.Now().After(cert.NotAfter) {
+		return nil
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// WriteTo writes the certificates in the given directory. Files are only
+// written if their content has changed.
+func (c *Certificates) WriteTo(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	for name, content := range map[string][]byte{CACertName: c.CACert, CertName: c.Cert, KeyName: c.Key} {
+		path := filepath.Join(dir, name)
+		if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, content) {
+			continue
+		}
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
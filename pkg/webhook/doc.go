@@ -0,0 +1,3 @@
+// webhook manages the admission webhook server of the controller, with its
+// self-managed certificates.
+package webhook
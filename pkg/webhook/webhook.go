@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+type (
+	// Options contains all options used to configure the webhook server.
+	Options struct {
+		// Port is the port where the webhook server listens; the webhook
+		// server is disabled if this port is not set.
+		Port int
+		// CertDir is the directory where the certificates are written.
+		CertDir string
+		// ServiceName and ServiceNamespace define the service used by the
+		// API server to reach the webhook server.
+		ServiceName      string
+		ServiceNamespace string
+		// ValidatingWebhookConfigurations are the names of the validating
+		// webhook configurations where the CA bundle must be injected.
+		ValidatingWebhookConfigurations []string
+		// MutatingWebhookConfigurations are the names of the mutating
+		// webhook configurations where the CA bundle must be injected.
+		MutatingWebhookConfigurations []string
+	}
+)
+
+// Enabled returns true if the webhook server must be started.
+func (opts Options) Enabled() bool { return opts.Port > 0 }
+
+// DNSNames returns all DNS names used to reach the webhook server.
+func (opts Options) DNSNames() []string {
+	return []string{
+		opts.ServiceName,
+		fmt.Sprintf("%s.%s", opts.ServiceName, opts.ServiceNamespace),
+		fmt.Sprintf("%s.%s.svc", opts.ServiceName, opts.ServiceNamespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", opts.ServiceName, opts.ServiceNamespace),
+	}
+}
+
+// Setup prepares the webhook server of the given manager: it ensures that
+// valid certificates exist (they are shared between all replicas through a
+// secret), writes them in the certificate directory and injects the CA
+// bundle into the webhook configurations. The certificates are then checked
+// periodically and renewed before they expire.
+// NOTE: the manager must be configured with the webhook port and the
+// certificate directory.
+func Setup(mgr manager.Manager, opts Options) error {
+	renewer := &certificateRenewer{reader: mgr.GetAPIReader(), writer: mgr.GetClient(), opts: opts, period: certRenewalPeriod}
+	if err := renewer.renew(); err != nil {
+		return err
+	}
+	return mgr.Add(renewer)
+}
+
+// certificateRenewer renews the certificates of the webhook server
+// periodically. It runs on all replicas, because each replica serves the
+// certificates from its own certificate directory.
+type certificateRenewer struct {
+	reader client.Reader
+	writer client.Writer
+	opts   Options
+	period time.Duration
+}
+
+// renew ensures that valid certificates exist, writes them in the
+// certificate directory (the webhook server reloads them) and injects the CA
+// bundle into the webhook configurations.
+func (r *certificateRenewer) renew() error {
+	certs, err := ensureCertificates(r.reader, r.writer, r.opts)
+	if err != nil {
+		return err
+	}
+
+	if err := certs.WriteTo(r.opts.CertDir); err != nil {
+		return err
+	}
+
+	return injectCABundle(r.reader, r.writer, r.opts, certs.CACert)
+}
+
+// Start implements manager.Runnable.
+func (r *certificateRenewer) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := r.renew(); err != nil {
+				klog.Errorf("failed to renew webhook certificates: %s", err)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *certificateRenewer) NeedLeaderElection() bool { return false }
+
+// ensureCertificates returns the certificates stored in the webhook
+// certificate secret. They are generated if they don't exist or if they must
+// be renewed.
+func ensureCertificates(reader client.Reader, writer client.Writer, opts Options) (*Certificates, error) {
+	ctx := context.TODO()
+	name := types.NamespacedName{Namespace: opts.ServiceNamespace, Name: opts.ServiceName + "-certs"}
+
+	secret := &corev1.Secret{}
+	err := reader.Get(ctx, name, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to fetch %T %s: %w", secret, name, err)
+	}
+	exists := err == nil
+
+	certs := &Certificates{CACert: secret.Data[CACertName], Cert: secret.Data[CertName], Key: secret.Data[KeyName]}
+	if exists && certs.IsValidFor(opts.DNSNames()) {
+		return certs, nil
+	}
+
+	klog.V(1).Infof("generate new webhook certificates, stored in %T %s", secret, name)
+	previousCA := validCACert(certs.CACert)
+	certs, err = GenerateCertificates(opts.DNSNames())
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: the previous CA is kept in the CA bundle, in order to trust the
+	//       replicas serving the previous certificates until they renew them
+	certs.CACert = append(certs.CACert, previousCA...)
+
+	secret.ObjectMeta = metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name, ResourceVersion: secret.ResourceVersion}
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{CACertName: certs.CACert, CertName: certs.Cert, KeyName: certs.Key}
+
+	if exists {
+		err = writer.Update(ctx, secret)
+	} else {
+		err = writer.Create(ctx, secret)
+	}
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		// NOTE: another replica has generated the certificates in the
+		//       meantime; use them instead
+		return ensureCertificates(reader, writer, opts)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to store webhook certificates in %T %s: %w", secret, name, err)
+	}
+	return certs, nil
+}
+
+// injectCABundle injects the given CA bundle into all webhooks of the
+// webhook configurations.
+func injectCABundle(reader client.Reader, writer client.Writer, opts Options, caBundle []byte) error {
+	for _, name := range opts.ValidatingWebhookConfigurations {
+		configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		err := injectCABundleInto(reader, writer, name, configuration, func() []*admissionregistrationv1.WebhookClientConfig {
+			clientConfigs := make([]*admissionregistrationv1.WebhookClientConfig, len(configuration.Webhooks))
+			for i := range configuration.Webhooks {
+				clientConfigs[i] = &configuration.Webhooks[i].ClientConfig
+			}
+			return clientConfigs
+		}, caBundle)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range opts.MutatingWebhookConfigurations {
+		configuration := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		err := injectCABundleInto(reader, writer, name, configuration, func() []*admissionregistrationv1.WebhookClientConfig {
+			clientConfigs := make([]*admissionregistrationv1.WebhookClientConfig, len(configuration.Webhooks))
+			for i := range configuration.Webhooks {
+				clientConfigs[i] = &configuration.Webhooks[i].ClientConfig
+			}
+			return clientConfigs
+		}, caBundle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectCABundleInto injects the given CA bundle into all client
+// configurations of a single webhook configuration.
+func injectCABundleInto(reader client.Reader, writer client.Writer, name string, configuration runtime.Object, clientConfigs func() []*admissionregistrationv1.WebhookClientConfig, caBundle []byte) error {
+	ctx := context.TODO()
+
+	err := reader.Get(ctx, types.NamespacedName{Name: name}, configuration)
+	switch {
+	case errors.IsNotFound(err):
+		klog.Warningf("%T %s not found, CA bundle not injected", configuration, name)
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to fetch %T %s: %w", configuration, name, err)
+	}
+
+	updated := false
+	for _, clientConfig := range clientConfigs() {
+		if !bytes.Equal(clientConfig.CABundle, caBundle) {
+			clientConfig.CABundle = caBundle
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	if err := writer.Update(ctx, configuration); err != nil {
+		return fmt.Errorf("failed to inject CA bundle in %T %s: %w", configuration, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var opts = Options{
+	Port:                            9443,
+	ServiceName:                     "sync-secrets-controller",
+	ServiceNamespace:                "default",
+	ValidatingWebhookConfigurations: []string{"sync-secrets-controller"},
+	MutatingWebhookConfigurations:   []string{"sync-secrets-controller-annotator"},
+}
+
+func TestCertificates_IsValidFor(t *testing.T) {
+	certs, err := GenerateCertificates(opts.DNSNames())
+	require.NoError(t, err)
+
+	assert.True(t, certs.IsValidFor(opts.DNSNames()))
+	assert.False(t, certs.IsValidFor([]string{"sync-secrets-controller.kube-system.svc"}))
+	assert.False(t, (&Certificates{}).IsValidFor(opts.DNSNames()))
+}
+
+func TestCertificates_WriteTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certs, err := GenerateCertificates(opts.DNSNames())
+	require.NoError(t, err)
+	require.NoError(t, certs.WriteTo(filepath.Join(dir, "webhook")))
+
+	for name, content := range map[string][]byte{CACertName: certs.CACert, CertName: certs.Cert, KeyName: certs.Key} {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, "webhook", name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, actual)
+	}
+}
+
+func TestEnsureCertificates(t *testing.T) {
+	client := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+	certs, err := ensureCertificates(client, client, opts)
+	require.NoError(t, err)
+
+	secret := &corev1.Secret{}
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "sync-secrets-controller-certs"}, secret))
+	assert.Equal(t, certs.Cert, secret.Data[CertName])
+
+	t.Run("WithExistingCertificates", func(t *testing.T) {
+		actual, err := ensureCertificates(client, client, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, certs, actual)
+	})
+
+	t.Run("WithCertificatesForAnotherService", func(t *testing.T) {
+		opts := opts
+		opts.ServiceName = "sync-secrets-controller"
+		opts.ServiceNamespace = "kube-system"
+		secret.Namespace = "kube-system"
+		secret.ResourceVersion = ""
+		require.NoError(t, client.Create(context.TODO(), secret))
+
+		actual, err := ensureCertificates(client, client, opts)
+		assert.NoError(t, err)
+		assert.NotEqual(t, certs.Cert, actual.Cert)
+		assert.True(t, actual.IsValidFor(opts.DNSNames()))
+	})
+}
+
+func TestEnsureCertificates_WithExpiringCertificates(t *testing.T) {
+	expiring, err := generateCertificates(opts.DNSNames(), certRenewBefore/2)
+	require.NoError(t, err)
+	require.False(t, expiring.IsValidFor(opts.DNSNames()))
+
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sync-secrets-controller-certs"},
+		Data:       map[string][]byte{CACertName: expiring.CACert, CertName: expiring.Cert, KeyName: expiring.Key},
+	})
+
+	certs, err := ensureCertificates(client, client, opts)
+	require.NoError(t, err)
+	assert.True(t, certs.IsValidFor(opts.DNSNames()))
+
+	// NOTE: the previous CA is still trusted, for the replicas which have
+	//       not renewed their certificates yet
+	assert.True(t, bytes.HasSuffix(certs.CACert, expiring.CACert))
+	assert.Equal(t, validCACert(certs.CACert), certs.CACert[:len(certs.CACert)-len(expiring.CACert)])
+}
+
+func TestCertificateRenewer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-secrets-controller"},
+		Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "annotations.secret.sync.klst.pw"}},
+	}
+	expiring, err := generateCertificates(opts.DNSNames(), certRenewBefore/2)
+	require.NoError(t, err)
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, configuration, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sync-secrets-controller-certs"},
+		Data:       map[string][]byte{CACertName: expiring.CACert, CertName: expiring.Cert, KeyName: expiring.Key},
+	})
+
+	opts := opts
+	opts.CertDir = dir
+	renewer := &certificateRenewer{reader: client, writer: client, opts: opts, period: 10 * time.Millisecond}
+	stop := make(chan struct{})
+	go func() { _ = renewer.Start(stop) }()
+	defer close(stop)
+
+	// NOTE: the certificates are renewed while the controller runs, and the
+	//       CA bundle is injected again
+	assert.Eventually(t, func() bool {
+		caBundle, err := ioutil.ReadFile(filepath.Join(dir, CACertName))
+		if err != nil || bytes.Equal(caBundle, expiring.CACert) {
+			return false
+		}
+		configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: "sync-secrets-controller"}, configuration)
+		return err == nil && bytes.Equal(caBundle, configuration.Webhooks[0].ClientConfig.CABundle)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInjectCABundle(t *testing.T) {
+	configuration := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-secrets-controller"},
+		Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "annotations.secret.sync.klst.pw"}},
+	}
+	mutatingConfiguration := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-secrets-controller-annotator"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "annotator.secret.sync.klst.pw"}},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, configuration, mutatingConfiguration)
+
+	require.NoError(t, injectCABundle(client, client, opts, []byte("ca-bundle")))
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Name: "sync-secrets-controller"}, configuration))
+	assert.Equal(t, []byte("ca-bundle"), configuration.Webhooks[0].ClientConfig.CABundle)
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Name: "sync-secrets-controller-annotator"}, mutatingConfiguration))
+	assert.Equal(t, []byte("ca-bundle"), mutatingConfiguration.Webhooks[0].ClientConfig.CABundle)
+
+	t.Run("WithoutConfiguration", func(t *testing.T) {
+		opts := opts
+		opts.ValidatingWebhookConfigurations = []string{"not-found"}
+		assert.NoError(t, injectCABundle(client, client, opts, []byte("ca-bundle")))
+	})
+}